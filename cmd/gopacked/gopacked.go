@@ -36,6 +36,13 @@ import (
 var installPath = flag.MakeFull("p", "path", "The path to save the modpack in.", "").String()
 var minecraftPath = flag.MakeFull("m", "minecraft", "The minecraft directory.", "").String()
 var side = flag.MakeFull("s", "side", "The side (client or server) to install.", string(gopacked.SideClient)).String()
+var jobs = flag.MakeFull("j", "jobs", "The maximum number of parallel downloads.", "8").Int()
+var hostJobs = flag.MakeFull("n", "host-jobs", "The maximum number of parallel downloads from a single host.", "4").Int()
+var cachePath = flag.MakeFull("c", "cache", "The download cache directory.", "").String()
+var cacheLimit = flag.MakeFull("l", "cache-limit", "The maximum size of the download cache in megabytes.", "2048").Int()
+var retrust = flag.MakeFull("t", "retrust", "Trust a new signing key for the goPack.", "false").Bool()
+var with = flag.MakeFull("w", "with", "Install the given optional component.", "").StringArray()
+var without = flag.MakeFull("x", "without", "Don't install the given optional component.", "").StringArray()
 var wantHelp, _ = flag.MakeHelpFlag()
 
 const help = `goPacked v0.4.1 - Simple command-line Minecraft modpack manager.
@@ -47,6 +54,14 @@ Available actions:
   install               Install the modpack from the given URL.
   update                Update the modpack by URL, name or install path.
   uninstall             Uninstall the modpack by URL, name or install path.
+  verify                Check the modpack for missing, changed or unexpected files.
+  repair                Reinstall the missing and changed files of the modpack.
+  cache list            List the files in the download cache.
+  cache prune           Remove old files until the cache fits in the size limit.
+  cache clear           Remove all files from the download cache.
+  lint                  Check the goPack definition at the given path or URL
+                        for mistakes.
+  schema                Print the JSON Schema of goPack definitions.
 
 Help options:
   -h, --help            Show this help page.
@@ -54,7 +69,19 @@ Help options:
 Application options:
   -p, --path=PATH       The path to save the modpack in.
   -m, --minecraft=PATH  The minecraft directory.
-  -s, --side=SIDE       The side (client or server) to install.`
+  -s, --side=SIDE       The side (client or server) to install.
+  -j, --jobs=N          The maximum number of parallel downloads. Defaults to 8.
+  -n, --host-jobs=N     The maximum number of parallel downloads from a single
+                        host. Defaults to 4.
+  -c, --cache=PATH      The download cache directory. Defaults to
+                        .minecraft/gopacked/.cache
+  -l, --cache-limit=MB  The maximum size of the download cache in megabytes.
+                        Defaults to 2048. Set to 0 to disable the cache.
+  -t, --retrust         Trust a new signing key for the goPack.
+  -w, --with=NAME       Install the given optional component without asking.
+                        Can be specified multiple times.
+  -x, --without=NAME    Don't install the given optional component. Can be
+                        specified multiple times.`
 
 func init() {
 	flag.SetHelpTitles("goPacked "+gopacked.GPVersion.String()+" - Simple command-line modpack manager.",
@@ -92,11 +119,21 @@ func main() {
 		*minecraftPath = os.Getenv("HOME")
 	}
 
+	if cachePath == nil || len(*cachePath) == 0 {
+		*cachePath = filepath.Join(*minecraftPath, "gopacked", ".cache")
+	}
+
 	action := strings.ToLower(flag.Arg(0))
 	if action == "install" && flag.NArg() > 1 {
 		install()
-	} else if action == "uninstall" || action == "update" {
+	} else if action == "uninstall" || action == "update" || action == "verify" || action == "repair" {
 		updateOrUninstall(action)
+	} else if action == "cache" && flag.NArg() > 1 {
+		cacheAction(strings.ToLower(flag.Arg(1)))
+	} else if action == "lint" && flag.NArg() > 1 {
+		lint(flag.Arg(1))
+	} else if action == "schema" {
+		fmt.Fprint(os.Stdout, gopacked.DefinitionSchema)
 	} else {
 		fmt.Fprintln(os.Stdout, help)
 	}
@@ -109,13 +146,35 @@ func install() {
 	if err != nil {
 		log.Fatalf("Failed to fetch goPack definition: %s", err)
 		return
+	} else if !checkTrust(gp.SimpleName, gp) {
+		return
 	}
 
 	if installPath == nil || len(*installPath) == 0 {
 		*installPath = filepath.Join(*minecraftPath, "gopacked", gp.SimpleName)
 	}
 
-	gp.Install(*installPath, *minecraftPath, gopacked.Side(*side))
+	choices := make(gopacked.Choices)
+	if !chooseComponents(gp, choices) {
+		return
+	}
+	gp.Install(newDownloader(gp), choices, *installPath, *minecraftPath, gopacked.Side(*side))
+}
+
+// chooseComponents applies the --with and --without flags to the given choices and asks the user about the rest of
+// the optional components in the goPack that haven't been chosen yet.
+func chooseComponents(gp gopacked.GoPack, choices gopacked.Choices) bool {
+	components := gp.Files.Components(gopacked.Side(*side))
+	err := choices.Choose(components, *with, true)
+	if err == nil {
+		err = choices.Choose(components, *without, false)
+	}
+	if err != nil {
+		log.Fatalf("Failed to choose optional components: %s", err)
+		return false
+	}
+	choices.Prompt(components)
+	return true
 }
 
 func updateOrUninstall(action string) {
@@ -135,17 +194,47 @@ func updateOrUninstall(action string) {
 		update(gp, updated)
 	} else if action == "uninstall" {
 		gp.Uninstall(*installPath, *minecraftPath, gopacked.Side(*side))
+	} else if action == "verify" || action == "repair" {
+		if len(gp.Name) == 0 {
+			log.Infof("Reading installed goPack definition from %s", *installPath)
+			err := readDefinition(&gp, *installPath)
+			if err != nil {
+				log.Fatalf("Failed to read local goPack definition: %s", err)
+				return
+			}
+		}
+		if action == "repair" {
+			gp.Repair(newDownloader(gp), *installPath, *minecraftPath, gopacked.Side(*side))
+		} else {
+			verify(gp)
+		}
+	}
+}
+
+func verify(gp gopacked.GoPack) {
+	problems := gp.Verify(*installPath, *minecraftPath, gopacked.Side(*side))
+	for _, problem := range problems {
+		if problem.Type == gopacked.ProblemUnexpected {
+			log.Warnf("File unexpected: %s", problem.Path)
+		} else {
+			log.Warnf("File %[1]s: %[2]s (%[3]s)", problem.Type, problem.Path, problem.Name)
+		}
+	}
+	if len(problems) == 0 {
+		log.Infof("No problems found")
+	} else {
+		log.Infof("Found %d problems, run gopacked repair to fix them", len(problems))
 	}
 }
 
 func getUpdateDefinitions() (gp gopacked.GoPack, updated gopacked.GoPack, ok bool) {
 	if flag.NArg() > 1 {
-		if strings.HasPrefix(flag.Arg(1), "http") {
+		if strings.HasPrefix(flag.Arg(1), "http") || strings.HasPrefix(flag.Arg(1), "file://") {
 			log.Infof("Fetching goPack definition from %s", flag.Arg(1))
 			err := fetchDefinition(&updated, flag.Arg(1))
 			if err != nil {
 				log.Fatalf("Failed to fetch goPack definition: %s", err)
-				ok = false
+				return
 			}
 		} else {
 			*installPath = filepath.Join(*minecraftPath, "gopacked", flag.Arg(1))
@@ -153,7 +242,7 @@ func getUpdateDefinitions() (gp gopacked.GoPack, updated gopacked.GoPack, ok boo
 			err := readDefinition(&gp, *installPath)
 			if err != nil {
 				log.Fatalf("Failed to read goPack definition: %s", err)
-				ok = false
+				return
 			}
 		}
 	} else {
@@ -161,7 +250,7 @@ func getUpdateDefinitions() (gp gopacked.GoPack, updated gopacked.GoPack, ok boo
 		err := readDefinition(&gp, *installPath)
 		if err != nil {
 			log.Fatalf("Failed to read goPack definition: %s", err)
-			ok = false
+			return
 		}
 	}
 	ok = true
@@ -174,6 +263,7 @@ func update(gp, updated gopacked.GoPack) {
 		err := readDefinition(&gp, *installPath)
 		if err != nil {
 			log.Fatalf("Failed to read local goPack definition: %s", err)
+			return
 		}
 	}
 
@@ -181,44 +271,263 @@ func update(gp, updated gopacked.GoPack) {
 		log.Infof("Fetching updated goPack definition from %s", gp.UpdateURL)
 		err := fetchDefinition(&updated, gp.UpdateURL)
 		if err != nil {
-			log.Fatalf("Failed to updated goPack definition: %s", err)
+			log.Fatalf("Failed to fetch updated goPack definition: %s", err)
+			return
+		}
+	}
+
+	if !checkTrust(gp.SimpleName, updated) {
+		return
+	}
+
+	choices, err := gopacked.LoadChoices(*installPath)
+	if err != nil {
+		log.Warnf("Failed to read optional component choices: %s", err)
+		choices = make(gopacked.Choices)
+	}
+	if !chooseComponents(updated, choices) {
+		return
+	}
+	gp.Update(newDownloader(updated), updated, choices, *installPath, *minecraftPath, gopacked.Side(*side))
+}
+
+// newDownloader creates a downloader for installing the given goPack.
+func newDownloader(gp gopacked.GoPack) *gopacked.Downloader {
+	dl := gopacked.NewDownloader(*jobs, *hostJobs)
+	dl.AllowLocalFiles = gp.IsLocal()
+	if *cacheLimit > 0 {
+		dl.Cache = gopacked.NewCache(*cachePath, int64(*cacheLimit)*1024*1024)
+	}
+	return dl
+}
+
+func cacheAction(action string) {
+	cache := gopacked.NewCache(*cachePath, int64(*cacheLimit)*1024*1024)
+	switch action {
+	case "list":
+		entries, err := cache.List()
+		if err != nil {
+			log.Fatalf("Failed to list download cache: %s", err)
+			return
+		}
+		var size int64
+		for _, entry := range entries {
+			fmt.Printf("%-72s %8.1f MB  %s\n", entry.Key, float64(entry.Size)/1024/1024, entry.LastUsed.Format("2006-01-02 15:04"))
+			size += entry.Size
+		}
+		log.Infof("%d files, %.1f MB in total", len(entries), float64(size)/1024/1024)
+	case "prune":
+		removed, freed, err := cache.Prune()
+		if err != nil {
+			log.Fatalf("Failed to prune download cache: %s", err)
+			return
+		}
+		log.Infof("Removed %d files (%.1f MB) from the download cache", removed, float64(freed)/1024/1024)
+	case "clear":
+		err := cache.Clear()
+		if err != nil {
+			log.Fatalf("Failed to clear download cache: %s", err)
+			return
+		}
+		log.Infof("Download cache cleared")
+	default:
+		fmt.Fprintln(os.Stdout, help)
+	}
+}
+
+func lint(source string) {
+	var data []byte
+	var err error
+	baseURL := source
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		log.Infof("Fetching goPack definition from %s", source)
+		data, err = fetchURL(source)
+	} else {
+		log.Infof("Reading goPack definition from %s", source)
+		data, err = ioutil.ReadFile(source)
+		if err == nil {
+			baseURL, err = gopacked.FileURL(source)
+		}
+	}
+	if err != nil {
+		log.Fatalf("Failed to read goPack definition: %s", err)
+		os.Exit(1)
+	}
+	// Definitions that can't be parsed are checked as-is, so that Lint can report the problems.
+	if resolved, err := gopacked.ResolveURLs(data, baseURL); err == nil {
+		data = resolved
+	}
+
+	var base struct {
+		Extends string `json:"extends"`
+	}
+	if json.Unmarshal(data, &base) == nil && len(base.Extends) != 0 {
+		log.Infof("Fetching parent goPack definition from %s", base.Extends)
+		var parent gopacked.GoPack
+		parentData, err := fetchExtendedDefinition(&parent, base.Extends, 1)
+		if err == nil {
+			data, err = gopacked.Extend(parentData, data)
+		}
+		if err != nil {
+			log.Fatalf("Failed to resolve parent goPack definition: %s", err)
+			os.Exit(1)
 		}
 	}
 
-	gp.Update(updated, *installPath, *minecraftPath, gopacked.Side(*side))
+	problems := gopacked.Lint(data)
+	for _, problem := range problems {
+		log.Warnf("%s", problem)
+	}
+	if len(problems) == 0 {
+		log.Infof("No problems found")
+	} else {
+		log.Errorf("Found %d problems", len(problems))
+		os.Exit(1)
+	}
 }
 
+// maxExtendsDepth is the maximum number of definitions a goPack definition can extend through its parents.
+const maxExtendsDepth = 8
+
 func fetchDefinition(gp *gopacked.GoPack, rawURL string) error {
+	_, err := fetchExtendedDefinition(gp, rawURL, 0)
+	if err == nil && len(gp.SigningKey) == 0 {
+		log.Warnf("goPack definition is not signed")
+	}
+	return err
+}
+
+// fetchExtendedDefinition fetches the definition at the given URL and the definitions it extends,
+// and returns the data of the resolved definition.
+func fetchExtendedDefinition(gp *gopacked.GoPack, rawURL string, depth int) ([]byte, error) {
 	fromURL, err := url.Parse(rawURL)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if len(fromURL.Scheme) == 0 {
 		fromURL.Scheme = "http"
 	}
-	response, err := http.Get(fromURL.String())
+	data, err := fetchURL(fromURL.String())
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer response.Body.Close()
-	data, err := ioutil.ReadAll(response.Body)
+
+	if len(data) == 0 {
+		return nil, fmt.Errorf("no data received")
+	}
+
+	// The signature covers the definition as it was published, so keep the original for verifying it.
+	original := data
+	data, err = gopacked.ResolveURLs(data, fromURL.String())
 	if err != nil {
-		return err
+		return nil, err
+	}
+	// The definition is only given to the caller after the signature has been verified.
+	var definition gopacked.GoPack
+	err = json.Unmarshal(data, &definition)
+	if err != nil {
+		return nil, err
 	}
+	definition.Source = fromURL.String()
 
-	if len(data) == 0 {
-		return fmt.Errorf("no data received")
+	signature, err := fetchURL(fromURL.String() + ".sig")
+	if err != nil && err != errNotFound {
+		return nil, fmt.Errorf("failed to fetch signature: %s", err)
+	}
+	err = definition.VerifySignature(original, signature)
+	if err != nil {
+		return nil, err
+	} else if len(definition.Extends) == 0 {
+		*gp = definition
+		return data, nil
+	} else if depth >= maxExtendsDepth {
+		return nil, fmt.Errorf("too many levels of extended definitions")
 	}
 
-	err = json.Unmarshal(data, &gp)
+	log.Infof("Fetching parent goPack definition from %s", definition.Extends)
+	var parent gopacked.GoPack
+	parentData, err := fetchExtendedDefinition(&parent, definition.Extends, depth+1)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("failed to fetch parent definition: %s", err)
+	} else if len(definition.SigningKey) != 0 && parent.SigningKey != definition.SigningKey {
+		// The signature of the child doesn't cover the parent, so it has to be signed by the same author.
+		return nil, fmt.Errorf("parent definition %s is not signed by the same key", definition.Extends)
 	}
-	return nil
+	data, err = gopacked.Extend(parentData, data)
+	if err != nil {
+		return nil, err
+	}
+	var extended gopacked.GoPack
+	err = json.Unmarshal(data, &extended)
+	if err != nil {
+		return nil, err
+	}
+	extended.Source = definition.Source
+	extended.Parent = &gopacked.ParentInfo{Name: parent.Name, URL: extended.Extends, Version: parent.Version}
+	*gp = extended
+	return data, nil
+}
+
+var errNotFound = fmt.Errorf("not found")
+
+func fetchURL(url string) ([]byte, error) {
+	response, err := gopacked.ClientFor(url).Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode == http.StatusNotFound {
+		return nil, errNotFound
+	} else if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return nil, fmt.Errorf("server returned %s", response.Status)
+	}
+	return ioutil.ReadAll(response.Body)
+}
+
+// checkTrust checks the signing key of the given definition against the key that was trusted when the goPack
+// with the given simple name was first installed, and trusts the key if there was no previous key.
+func checkTrust(simpleName string, gp gopacked.GoPack) bool {
+	store, err := gopacked.LoadTrustStore(filepath.Join(*minecraftPath, "gopacked", "trusted-keys.json"))
+	if err != nil {
+		log.Fatalf("Failed to load trusted keys: %s", err)
+		return false
+	}
+	err = store.Check(simpleName, gp.SigningKey)
+	if err != nil && *retrust {
+		log.Warnf("%s, trusting new key as requested", err)
+	} else if err != nil {
+		log.Fatalf("Refusing to continue: %s", err)
+		log.Fatalf("If you trust the new key, run the command again with --retrust")
+		return false
+	} else if len(gp.SigningKey) == 0 {
+		return true
+	}
+	store.Trust(simpleName, gp.SigningKey)
+	err = store.Save()
+	if err != nil {
+		log.Warnf("Failed to save trusted keys: %s", err)
+	}
+	return true
 }
 
 func readDefinition(gp *gopacked.GoPack, path string) error {
-	data, err := ioutil.ReadFile(filepath.Join(path, "gopacked.json"))
+	path = filepath.Join(path, "gopacked.json")
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	location, err := gopacked.FileURL(path)
+	if err != nil {
+		return err
+	}
+	// Definitions are saved with resolved URLs, but older ones may still contain relative URLs. If the original source
+	// is unknown, they're resolved against the saved copy, but the definition isn't treated as local.
+	source := gopacked.SavedDefinitionSource(data)
+	base := source
+	if len(base) == 0 {
+		base = location
+	}
+	data, err = gopacked.ResolveURLs(data, base)
 	if err != nil {
 		return err
 	}
@@ -226,5 +535,6 @@ func readDefinition(gp *gopacked.GoPack, path string) error {
 	if err != nil {
 		return err
 	}
+	gp.Source = source
 	return nil
 }
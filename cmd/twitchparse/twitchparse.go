@@ -18,6 +18,8 @@ package main
 
 import (
 	"archive/zip"
+	"crypto/ed25519"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -36,13 +38,15 @@ import (
 
 var outputPath = flag.MakeFull("o", "output", "The file to output the modpack to.", "modpack.json").String()
 var extraOutputPath = flag.MakeFull("e", "extra-output", "The directory to output extra files that need to to be served under --web-prefix.", "modpackextra").String()
-var webPrefix = flag.MakeFull("w", "web-prefix", "The URL prefix for files that need to be hosted somewhere (e.g. https://example.com/modpack)", "https://example.com/modpack").String()
+var webPrefix = flag.MakeFull("w", "web-prefix", "The URL prefix for files that need to be hosted somewhere (e.g. https://example.com/modpack). If empty, relative URLs are used.", "https://example.com/modpack").String()
+var mirror = flag.MakeFull("r", "mirror", "Download the mods into --extra-output and add them as mirrors under --web-prefix.", "false").Bool()
+var signingKeyPath = flag.MakeFull("k", "signing-key", "The file containing the ed25519 key to sign the goPack with. A new key is generated if the file doesn't exist.", "").String()
 var wantHelp, _ = flag.MakeHelpFlag()
 
 const help = `goPacked Twitch modpack parser v0.1.0
 
 Usage:
-  twitchparse [-h] [-o PATH] [-w HOST] <INPUT PATH>
+  twitchparse [-h] [-r] [-o PATH] [-w HOST] [-k PATH] <INPUT PATH>
 
 Help options:
   -h, --help            Show this help page.
@@ -52,11 +56,33 @@ Application options:
   -e, --extra-output=PATH  The directory to output extra files that need to to
                            be served under --web-prefix. Defaults to modpackextra.
   -w, --web-prefix=HOST    The URL prefix for files that need to be hosted
-                           somewhere (e.g. https://example.com/modpack).`
+                           somewhere. Defaults to https://example.com/modpack.
+                           If set to an empty string (-w ""), the URLs are
+                           relative to the goPack definition, which must then
+                           be served together with --extra-output.
+  -r, --mirror             Download the mods into --extra-output/mirror and add
+                           them as mirrors under --web-prefix.
+  -k, --signing-key=PATH   The file containing the ed25519 key to sign the goPack
+                           with. A new key is generated if the file doesn't exist.`
+
+// webURL returns the URL of the given file in --extra-output. If --web-prefix is empty, the URL is relative to the
+// goPack definition, which is assumed to be served from the same directory. The empty path returns the URL of the
+// definition itself.
+func webURL(path string) string {
+	if len(*webPrefix) == 0 {
+		if len(path) == 0 {
+			return filepath.Base(*outputPath)
+		}
+		return path
+	} else if len(path) == 0 {
+		return *webPrefix
+	}
+	return strings.TrimSuffix(*webPrefix, "/") + "/" + path
+}
 
 func main() {
 	flag.SetHelpTitles("goPacked Twitch modpack parser v0.1.0",
-		"twitchparse [-h] [-o PATH] [-w HOST] <INPUT PATH>")
+		"twitchparse [-h] [-r] [-o PATH] [-w HOST] [-k PATH] <INPUT PATH>")
 	err := flag.Parse()
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
@@ -139,7 +165,7 @@ func main() {
 					packFiles["override/"+file.Name()] = gopacked.FileEntry{
 						Type:     gopacked.TypeZipArchive,
 						FileName: file.Name(),
-						URL:      *webPrefix + "/" + file.Name() + ".zip",
+						URL:      webURL(file.Name() + ".zip"),
 					}
 				} else {
 					modOverrides, err := ioutil.ReadDir(fileTempPath)
@@ -168,13 +194,13 @@ func main() {
 							packFiles["override/mods/"+modOverride.Name()] = gopacked.FileEntry{
 								Type:     gopacked.TypeZipArchive,
 								FileName: modOverride.Name(),
-								URL:      *webPrefix + "/mods/" + modOverride.Name() + ".zip",
+								URL:      webURL("mods/" + modOverride.Name() + ".zip"),
 							}
 						} else {
 							packFiles["override/mods/"+modOverride.Name()] = gopacked.FileEntry{
 								Type:     gopacked.TypeFile,
 								FileName: modOverride.Name(),
-								URL:      *webPrefix + "/mods/" + modOverride.Name(),
+								URL:      webURL("mods/" + modOverride.Name()),
 							}
 							err = os.Rename(modTempPath, filepath.Join(modOutputDir, modOverride.Name()))
 							if err != nil {
@@ -187,7 +213,7 @@ func main() {
 				packFiles["override/"+file.Name()] = gopacked.FileEntry{
 					Type:     gopacked.TypeFile,
 					FileName: file.Name(),
-					URL:      *webPrefix + "/" + file.Name(),
+					URL:      webURL(file.Name()),
 				}
 				err = os.Rename(fileTempPath, filepath.Join(eop, file.Name()))
 				if err != nil {
@@ -212,12 +238,19 @@ func main() {
 	log.Infof("Converting mods to goPack format")
 	mods := map[string]gopacked.FileEntry{}
 	for _, mod := range packManifest.Files {
-		mods[mod.ModData.Name] = gopacked.FileEntry{
+		entry := gopacked.FileEntry{
 			Type:     gopacked.TypeFile,
-			Version:  gopacked.Version{mod.FileData.ID},
+			Version:  gopacked.Version(strconv.Itoa(mod.FileData.ID)),
 			FileName: mod.FileData.DiskFileName,
 			URL:      mod.FileData.URL,
 		}
+		if *mirror {
+			entry.Mirrors = []string{webURL("mirror/" + mod.FileData.DiskFileName)}
+		}
+		mods[mod.ModData.Name] = entry
+	}
+	if *mirror {
+		downloadMirror(mods)
 	}
 	packFiles["mods"] = gopacked.FileEntry{
 		Type:     gopacked.TypeDirectory,
@@ -227,22 +260,24 @@ func main() {
 	log.Infof("Converting pack info to goPack format")
 	simpleName := strings.ToLower(strings.Replace(packManifest.Name, " ", "", -1))
 	gopack := gopacked.GoPack{
-		Name:        packManifest.Name,
-		SimpleName:  simpleName,
-		Author:      packManifest.Author,
-		Version:     packManifest.Version,
-		ForgeVer:    forgeVer,
-		UpdateURL:   *webPrefix,
-		ProfileArgs: map[string]interface{}{},
-		GoPackedMin: gopacked.Version{0, 4, 0, 0},
+		FormatVersion: gopacked.CurrentFormatVersion,
+		Name:          packManifest.Name,
+		SimpleName:    simpleName,
+		Author:        packManifest.Author,
+		Version:       packManifest.Version,
+		MCVersion:     packManifest.Minecraft.Version,
+		ForgeVer:      forgeVer,
+		UpdateURL:     webURL(""),
+		ProfileArgs:   map[string]interface{}{},
+		GoPackedReq:   gopacked.Constraint{{Operator: gopacked.OpGreaterOrEqual, Version: gopacked.Version("0.4.0.0")}},
 		MCLVersion: gopacked.FileEntry{
 			Type: gopacked.TypeDirectory,
 			Children: map[string]gopacked.FileEntry{
 				"Version JSON": {
 					Type:     gopacked.TypeFile,
 					FileName: simpleName + ".json",
-					Version:  gopacked.Version{1},
-					URL:      *webPrefix + "/version.json",
+					Version:  gopacked.Version("1"),
+					URL:      webURL("version.json"),
 				},
 			},
 		},
@@ -252,6 +287,12 @@ func main() {
 		},
 	}
 
+	var signingKey ed25519.PrivateKey
+	if len(*signingKeyPath) > 0 {
+		signingKey = loadSigningKey(*signingKeyPath)
+		gopack.SigningKey = base64.StdEncoding.EncodeToString(signingKey.Public().(ed25519.PublicKey))
+	}
+
 	log.Infof("Marshaling and writing finished goPack file to disk")
 	data, err := json.Marshal(&gopack)
 	if err != nil {
@@ -261,5 +302,62 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
+	if signingKey != nil {
+		log.Infof("Writing goPack signature to %s.sig", *outputPath)
+		signature := base64.StdEncoding.EncodeToString(ed25519.Sign(signingKey, data))
+		err = ioutil.WriteFile(*outputPath+".sig", []byte(signature), 0644)
+		if err != nil {
+			panic(err)
+		}
+	}
 	log.Infof("All done")
 }
+
+func downloadMirror(mods map[string]gopacked.FileEntry) {
+	log.Infof("Downloading mods for the mirror")
+	mirrorDir, err := filepath.Abs(filepath.Join(*extraOutputPath, "mirror"))
+	if err != nil {
+		panic(err)
+	}
+	err = os.MkdirAll(mirrorDir, 0755)
+	if err != nil {
+		panic(err)
+	}
+	dl := gopacked.NewDownloader(8, 4)
+	for name, mod := range mods {
+		dl.Add(&gopacked.DownloadTask{
+			Name:  name,
+			Entry: gopacked.FileEntry{Type: mod.Type, URL: mod.URL},
+			Path:  filepath.Join(mirrorDir, mod.FileName),
+		})
+	}
+	if gopacked.ReportErrors(dl.Run()) {
+		panic("failed to download mods for the mirror")
+	}
+}
+
+func loadSigningKey(path string) ed25519.PrivateKey {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		log.Infof("Generating new signing key to %s", path)
+		var key ed25519.PrivateKey
+		_, key, err = ed25519.GenerateKey(nil)
+		if err != nil {
+			panic(err)
+		}
+		err = ioutil.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(key.Seed())), 0600)
+		if err != nil {
+			panic(err)
+		}
+		return key
+	} else if err != nil {
+		panic(err)
+	}
+	seed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		panic(err)
+	} else if len(seed) != ed25519.SeedSize {
+		panic(fmt.Sprintf("invalid signing key length %d", len(seed)))
+	}
+	return ed25519.NewKeyFromSeed(seed)
+}
@@ -19,31 +19,112 @@ package archive
 import (
 	"archive/tar"
 	"archive/zip"
-	"bytes"
 	"compress/bzip2"
 	"compress/gzip"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
-func Untargz(from io.Reader, target string) error {
-	var buf bytes.Buffer
-	err := Ungz(from, &buf)
+// UnsafePathError is returned when an archive entry would be extracted outside the target directory.
+type UnsafePathError struct {
+	Name   string
+	Target string
+	Reason string
+}
+
+func (err UnsafePathError) Error() string {
+	return fmt.Sprintf("unsafe archive entry %s: %s", err.Name, err.Reason)
+}
+
+// IsUnsafePath checks whether or not the given error is an UnsafePathError.
+func IsUnsafePath(err error) bool {
+	_, ok := err.(UnsafePathError)
+	return ok
+}
+
+// SafeJoin joins the given archive entry name to the target directory. An UnsafePathError is returned if the entry
+// name is absolute or if the resulting path would be outside the target directory, either directly or through
+// a symlink that already exists inside the target.
+func SafeJoin(target, name string) (string, error) {
+	if filepath.IsAbs(name) || strings.HasPrefix(name, "/") || strings.HasPrefix(name, "\\") || len(filepath.VolumeName(name)) != 0 {
+		return "", UnsafePathError{name, target, "absolute path"}
+	}
+	path := filepath.Join(target, name)
+	if !isWithin(target, path) {
+		return "", UnsafePathError{name, target, "path outside target directory"}
+	}
+	err := checkSymlinks(target, path, name)
 	if err != nil {
-		return err
+		return "", err
+	}
+	return path, nil
+}
+
+// checkSymlinks makes sure that none of the existing components of path are symlinks that point outside target.
+func checkSymlinks(target, path, name string) error {
+	rel, err := filepath.Rel(target, path)
+	if err != nil || rel == "." {
+		return nil
+	}
+	realTarget, err := filepath.EvalSymlinks(target)
+	if err != nil {
+		// The target doesn't exist yet, so there can't be any symlinks inside it.
+		return nil
 	}
-	return Untar(&buf, target)
+	current := target
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		current = filepath.Join(current, part)
+		info, err := os.Lstat(current)
+		if err != nil {
+			return nil
+		} else if info.Mode()&os.ModeSymlink == 0 {
+			continue
+		}
+		resolved, err := filepath.EvalSymlinks(current)
+		if err != nil || !isWithin(realTarget, resolved) {
+			return UnsafePathError{name, target, "path goes through a symlink outside target directory"}
+		}
+	}
+	return nil
+}
+
+// checkLink makes sure that a symlink at path pointing to linkName would stay inside target.
+func checkLink(target, path, name, linkName string) error {
+	if filepath.IsAbs(linkName) || strings.HasPrefix(linkName, "/") || len(filepath.VolumeName(linkName)) != 0 {
+		return UnsafePathError{name, target, "symlink to absolute path"}
+	} else if !isWithin(target, filepath.Join(filepath.Dir(path), linkName)) {
+		return UnsafePathError{name, target, "symlink to outside target directory"}
+	}
+	return nil
+}
+
+func isWithin(base, path string) bool {
+	rel, err := filepath.Rel(base, path)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
 }
 
-func Untarbz2(from io.Reader, target string) error {
-	var buf bytes.Buffer
-	err := Unbz2(from, &buf)
+// Untargz extracts a gzip-compressed tar stream to the target directory. The stream is decompressed and extracted
+// on the fly, so the archive is never held in memory as a whole.
+func Untargz(from io.Reader, target string, filter *Filter) error {
+	reader, err := gzip.NewReader(from)
 	if err != nil {
 		return err
 	}
-	return Untar(&buf, target)
+	defer reader.Close()
+	return Untar(reader, target, filter)
+}
+
+// Untarbz2 extracts a bzip2-compressed tar stream to the target directory. Like Untargz, the stream is extracted
+// on the fly.
+func Untarbz2(from io.Reader, target string, filter *Filter) error {
+	return Untar(bzip2.NewReader(from), target, filter)
 }
 
 func Ungz(from io.Reader, to io.Writer) error {
@@ -51,6 +132,7 @@ func Ungz(from io.Reader, to io.Writer) error {
 	if err != nil {
 		return err
 	}
+	defer reader.Close()
 	_, err = io.Copy(to, reader)
 	return err
 }
@@ -61,7 +143,8 @@ func Unbz2(from io.Reader, to io.Writer) error {
 	return err
 }
 
-func Untar(from io.Reader, target string) error {
+// Untar extracts a tar stream to the target directory. Only the entries accepted by the filter are extracted.
+func Untar(from io.Reader, target string, filter *Filter) error {
 	tarReader := tar.NewReader(from)
 
 	for {
@@ -72,7 +155,17 @@ func Untar(from io.Reader, target string) error {
 			return err
 		}
 
-		err = UnarchiveTarFile(header, target, tarReader)
+		name, ok := filter.Apply(header.Name)
+		if !ok {
+			continue
+		} else if header.Typeflag == tar.TypeLink {
+			header.Linkname, ok = filter.Apply(header.Linkname)
+			if !ok {
+				// The link target wasn't extracted, so there's nothing to link to.
+				continue
+			}
+		}
+		err = unarchiveTarFile(header, name, target, tarReader)
 		if err != nil {
 			return err
 		}
@@ -81,26 +174,49 @@ func Untar(from io.Reader, target string) error {
 }
 
 func UnarchiveTarFile(header *tar.Header, target string, reader io.Reader) error {
-	path := filepath.Join(target, header.Name)
+	return unarchiveTarFile(header, header.Name, target, reader)
+}
+
+func unarchiveTarFile(header *tar.Header, name, target string, reader io.Reader) error {
+	path, err := SafeJoin(target, name)
+	if err != nil {
+		return err
+	}
 	info := header.FileInfo()
-	if info.IsDir() {
-		if err := os.MkdirAll(path, info.Mode()); err != nil {
+	switch header.Typeflag {
+	case tar.TypeDir:
+		return os.MkdirAll(path, info.Mode())
+	case tar.TypeSymlink:
+		return UnarchiveSymlink(target, path, name, header.Linkname)
+	case tar.TypeLink:
+		linkPath, err := SafeJoin(target, header.Linkname)
+		if err != nil {
 			return err
 		}
+		return os.Link(linkPath, path)
+	case tar.TypeReg, tar.TypeRegA:
+		return UnarchiveGenericFile(path, info, reader)
+	default:
+		// Devices, FIFOs and other special files have no place in a modpack.
 		return nil
 	}
-
-	return UnarchiveGenericFile(path, info, reader)
 }
 
-func Unzip(archive, target string) error {
+// Unzip extracts the zip file at the given path to the target directory. Only the entries accepted by the filter
+// are extracted.
+func Unzip(archive, target string, filter *Filter) error {
 	reader, err := zip.OpenReader(archive)
 	if err != nil {
 		return err
 	}
+	defer reader.Close()
 
 	for _, file := range reader.File {
-		err = UnarchiveZipFile(file, target)
+		name, ok := filter.Apply(file.Name)
+		if !ok {
+			continue
+		}
+		err = unarchiveZipFile(file, name, target)
 		if err != nil {
 			return err
 		}
@@ -110,7 +226,14 @@ func Unzip(archive, target string) error {
 }
 
 func UnarchiveZipFile(file *zip.File, target string) error {
-	path := filepath.Join(target, file.Name)
+	return unarchiveZipFile(file, file.Name, target)
+}
+
+func unarchiveZipFile(file *zip.File, name, target string) error {
+	path, err := SafeJoin(target, name)
+	if err != nil {
+		return err
+	}
 	if file.FileInfo().IsDir() {
 		_ = os.MkdirAll(path, file.Mode())
 		return nil
@@ -122,10 +245,35 @@ func UnarchiveZipFile(file *zip.File, target string) error {
 	}
 	defer fileReader.Close()
 
+	if file.Mode()&os.ModeSymlink != 0 {
+		linkName, err := ioutil.ReadAll(fileReader)
+		if err != nil {
+			return err
+		}
+		return UnarchiveSymlink(target, path, name, string(linkName))
+	}
+
 	return UnarchiveGenericFile(path, file.FileInfo(), fileReader)
 }
 
+// UnarchiveSymlink creates a symlink at path pointing to linkName, as long as the symlink doesn't point outside target.
+func UnarchiveSymlink(target, path, name, linkName string) error {
+	err := checkLink(target, path, name, linkName)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	return os.Symlink(linkName, path)
+}
+
 func UnarchiveGenericFile(toPath string, fileInfo os.FileInfo, reader io.Reader) error {
+	err := os.MkdirAll(filepath.Dir(toPath), 0755)
+	if err != nil {
+		return err
+	}
 	file, err := os.OpenFile(toPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, fileInfo.Mode())
 	if err != nil {
 		return err
@@ -0,0 +1,357 @@
+// goPacked - A simple text-based Minecraft modpack manager.
+// Copyright (C) 2019 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "gopacked-archive-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// entry is a file in a test archive. Entries with a link name are symlinks.
+type entry struct {
+	name     string
+	content  string
+	linkName string
+}
+
+func makeTar(t *testing.T, entries ...entry) *bytes.Buffer {
+	var buf bytes.Buffer
+	writer := tar.NewWriter(&buf)
+	for _, file := range entries {
+		header := &tar.Header{Name: file.name, Mode: 0644, Size: int64(len(file.content)), Typeflag: tar.TypeReg}
+		if len(file.linkName) != 0 {
+			header = &tar.Header{Name: file.name, Mode: 0777, Linkname: file.linkName, Typeflag: tar.TypeSymlink}
+		}
+		if err := writer.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		} else if _, err = writer.Write([]byte(file.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+func makeZip(t *testing.T, path string, entries ...entry) {
+	var buf bytes.Buffer
+	writer := zip.NewWriter(&buf)
+	for _, file := range entries {
+		header := &zip.FileHeader{Name: file.name, Method: zip.Deflate}
+		content := file.content
+		if len(file.linkName) != 0 {
+			header.SetMode(os.ModeSymlink | 0777)
+			content = file.linkName
+		} else {
+			header.SetMode(0644)
+		}
+		w, err := writer.CreateHeader(header)
+		if err != nil {
+			t.Fatal(err)
+		} else if _, err = w.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSafeJoin(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	target := filepath.Join(dir, "target")
+	if err := os.MkdirAll(filepath.Join(target, "inside"), 0755); err != nil {
+		t.Fatal(err)
+	} else if err = os.Symlink(dir, filepath.Join(target, "escape")); err != nil {
+		t.Fatal(err)
+	} else if err = os.Symlink("inside", filepath.Join(target, "local")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		expected string
+		unsafe   bool
+	}{
+		{"config/a.cfg", filepath.Join(target, "config", "a.cfg"), false},
+		{"config/../a.cfg", filepath.Join(target, "a.cfg"), false},
+		{"./a.cfg", filepath.Join(target, "a.cfg"), false},
+		{"..", "", true},
+		{"../../.bashrc", "", true},
+		{"config/../../target2/a.cfg", "", true},
+		{"/etc/passwd", "", true},
+		{"\\windows\\system32", "", true},
+		{"escape/a.cfg", "", true},
+		{"escape", "", true},
+		{"local/a.cfg", filepath.Join(target, "local", "a.cfg"), false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path, err := SafeJoin(target, test.name)
+			if test.unsafe && !IsUnsafePath(err) {
+				t.Errorf("expected an unsafe path error, got %q, %v", path, err)
+			} else if !test.unsafe && (err != nil || path != test.expected) {
+				t.Errorf("expected %q, got %q, %v", test.expected, path, err)
+			}
+		})
+	}
+}
+
+// unsafeTarget creates a target directory inside dir that contains a symlink pointing back to dir.
+func unsafeTarget(t *testing.T, dir string) string {
+	target := filepath.Join(dir, "target")
+	if err := os.Mkdir(target, 0755); err != nil {
+		t.Fatal(err)
+	} else if err = os.Symlink(dir, filepath.Join(target, "escape")); err != nil {
+		t.Fatal(err)
+	}
+	return target
+}
+
+func TestUntarUnsafeEntries(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []entry
+	}{
+		{"path traversal", []entry{{name: "../evil.txt", content: "x"}}},
+		{"absolute path", []entry{{name: "/tmp/evil.txt", content: "x"}}},
+		{"absolute symlink", []entry{{name: "link", linkName: "/etc"}}},
+		{"symlink outside target", []entry{{name: "config/link", linkName: "../../.."}}},
+		{"existing symlink outside target", []entry{{name: "escape/evil.txt", content: "x"}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := tempDir(t)
+			defer os.RemoveAll(dir)
+			err := Untar(makeTar(t, test.entries...), unsafeTarget(t, dir), nil)
+			if !IsUnsafePath(err) {
+				t.Errorf("expected an unsafe path error, got %v", err)
+			}
+			if _, err = os.Stat(filepath.Join(dir, "evil.txt")); err == nil {
+				t.Error("file was written outside the target directory")
+			}
+		})
+	}
+}
+
+func TestUnzipUnsafeEntries(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []entry
+	}{
+		{"path traversal", []entry{{name: "../evil.txt", content: "x"}}},
+		{"nested path traversal", []entry{{name: "config/../../evil.txt", content: "x"}}},
+		{"absolute path", []entry{{name: "/tmp/evil.txt", content: "x"}}},
+		{"symlink outside target", []entry{{name: "link", linkName: "../"}}},
+		{"existing symlink outside target", []entry{{name: "escape/evil.txt", content: "x"}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := tempDir(t)
+			defer os.RemoveAll(dir)
+			archive := filepath.Join(dir, "archive.zip")
+			makeZip(t, archive, test.entries...)
+			err := Unzip(archive, unsafeTarget(t, dir), nil)
+			if !IsUnsafePath(err) {
+				t.Errorf("expected an unsafe path error, got %v", err)
+			}
+			if _, err = os.Stat(filepath.Join(dir, "evil.txt")); err == nil {
+				t.Error("file was written outside the target directory")
+			}
+		})
+	}
+}
+
+func TestUntarSafeEntries(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	archive := makeTar(t,
+		entry{name: "config/a.cfg", content: "a"},
+		entry{name: "config/link.cfg", linkName: "a.cfg"},
+		entry{name: "./b.cfg", content: "b"},
+	)
+	if err := Untar(archive, dir, nil); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for path, expected := range map[string]string{"config/a.cfg": "a", "config/link.cfg": "a", "b.cfg": "b"} {
+		if data, err := ioutil.ReadFile(filepath.Join(dir, path)); err != nil || string(data) != expected {
+			t.Errorf("expected %s to contain %q, got %q, %v", path, expected, data, err)
+		}
+	}
+}
+
+// largeFileSize is the size of the file in the synthetic archives. The archives compress to almost nothing, but
+// buffering the decompressed data in memory would exceed maxHeapGrowth many times over.
+const largeFileSize = 128 << 20
+const maxHeapGrowth = 32 << 20
+
+// zeroReader is an endless stream of zeros.
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+// peakHeapGrowth runs fn and returns how much the heap grew at most while it was running.
+func peakHeapGrowth(fn func()) uint64 {
+	runtime.GC()
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	baseline := stats.HeapAlloc
+
+	done := make(chan struct{})
+	peak := make(chan uint64)
+	go func() {
+		var max uint64
+		ticker := time.NewTicker(5 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			var stats runtime.MemStats
+			runtime.ReadMemStats(&stats)
+			if stats.HeapAlloc > baseline && stats.HeapAlloc-baseline > max {
+				max = stats.HeapAlloc - baseline
+			}
+			select {
+			case <-done:
+				peak <- max
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	fn()
+	close(done)
+	return <-peak
+}
+
+// writeLargeTar writes a tar archive containing a single large file of zeros.
+func writeLargeTar(w io.Writer) error {
+	writer := tar.NewWriter(w)
+	err := writer.WriteHeader(&tar.Header{Name: "world/region.mca", Mode: 0644, Size: largeFileSize, Typeflag: tar.TypeReg})
+	if err != nil {
+		return err
+	}
+	_, err = io.CopyN(writer, zeroReader{}, largeFileSize)
+	if err != nil {
+		return err
+	}
+	return writer.Close()
+}
+
+func checkLargeFile(t *testing.T, path string) {
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("large file wasn't extracted: %s", err)
+	} else if info.Size() != largeFileSize {
+		t.Errorf("expected the extracted file to be %d bytes, got %d", largeFileSize, info.Size())
+	}
+}
+
+func TestUntargzMemory(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping large archive test in short mode")
+	}
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	// The archive is generated on the fly, so the test itself doesn't hold it in memory either.
+	reader, writer := io.Pipe()
+	go func() {
+		gz, _ := gzip.NewWriterLevel(writer, gzip.BestSpeed)
+		err := writeLargeTar(gz)
+		if err == nil {
+			err = gz.Close()
+		}
+		_ = writer.CloseWithError(err)
+	}()
+	var err error
+	growth := peakHeapGrowth(func() {
+		err = Untargz(reader, dir, nil)
+	})
+	if err != nil {
+		t.Fatalf("extraction failed: %s", err)
+	}
+	checkLargeFile(t, filepath.Join(dir, "world", "region.mca"))
+	if growth > maxHeapGrowth {
+		t.Errorf("extracting used %d MiB of memory, expected at most %d MiB", growth>>20, maxHeapGrowth>>20)
+	}
+}
+
+func TestUnzipMemory(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping large archive test in short mode")
+	}
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	archive := filepath.Join(dir, "world.zip")
+	file, err := os.Create(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer := zip.NewWriter(file)
+	entry, err := writer.CreateHeader(&zip.FileHeader{Name: "world/region.mca", Method: zip.Deflate})
+	if err == nil {
+		_, err = io.CopyN(entry, zeroReader{}, largeFileSize)
+	}
+	if err == nil {
+		err = writer.Close()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		t.Fatalf("failed to create archive: %s", err)
+	}
+
+	target := filepath.Join(dir, "target")
+	growth := peakHeapGrowth(func() {
+		err = Unzip(archive, target, nil)
+	})
+	if err != nil {
+		t.Fatalf("extraction failed: %s", err)
+	}
+	checkLargeFile(t, filepath.Join(target, "world", "region.mca"))
+	if growth > maxHeapGrowth {
+		t.Errorf("extracting used %d MiB of memory, expected at most %d MiB", growth>>20, maxHeapGrowth>>20)
+	}
+}
@@ -0,0 +1,99 @@
+// goPacked - A simple text-based Minecraft modpack manager.
+// Copyright (C) 2019 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package archive
+
+import (
+	"path"
+	"strings"
+)
+
+// Filter selects which entries of an archive are extracted and where.
+// A nil filter extracts everything as-is.
+type Filter struct {
+	// StripComponents is the number of leading path components to remove from entry names.
+	// Entries with fewer components are skipped.
+	StripComponents int
+	// SubPath is a directory in the archive (after stripping components). If set, only the contents of the
+	// directory are extracted, relative to the directory.
+	SubPath string
+	// Include contains glob patterns of the entries to extract. If empty, all entries are included.
+	Include []string
+	// Exclude contains glob patterns of the entries not to extract.
+	Exclude []string
+}
+
+// Apply returns the name the archive entry with the given name should be extracted as,
+// or false if the entry should be skipped.
+//
+// Glob patterns are matched using path.Match against the entry name after stripping components and the sub-path.
+// Patterns without a slash are also matched against the base name, and a pattern matching a directory matches
+// everything inside it.
+func (filter *Filter) Apply(name string) (string, bool) {
+	name = strings.TrimPrefix(strings.Replace(name, "\\", "/", -1), "./")
+	if filter == nil {
+		return name, true
+	}
+	isDir := strings.HasSuffix(name, "/")
+	name = strings.Trim(name, "/")
+
+	if filter.StripComponents > 0 {
+		parts := strings.Split(name, "/")
+		if len(parts) <= filter.StripComponents {
+			return "", false
+		}
+		name = strings.Join(parts[filter.StripComponents:], "/")
+	}
+
+	if subPath := strings.Trim(filter.SubPath, "/"); len(subPath) != 0 {
+		if !strings.HasPrefix(name, subPath+"/") {
+			return "", false
+		}
+		name = strings.TrimPrefix(name, subPath+"/")
+	}
+
+	if len(filter.Include) > 0 && !matchAny(filter.Include, name) {
+		// Directories are always created when something is included, so that
+		// patterns like "config/*.cfg" don't need to match the directory.
+		if !isDir {
+			return "", false
+		}
+	}
+	if matchAny(filter.Exclude, name) {
+		return "", false
+	}
+	if isDir {
+		name += "/"
+	}
+	return name, true
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		pattern = strings.Trim(pattern, "/")
+		for dir := name; len(dir) != 0 && dir != "."; dir = path.Dir(dir) {
+			if ok, _ := path.Match(pattern, dir); ok {
+				return true
+			}
+		}
+		if !strings.Contains(pattern, "/") {
+			if ok, _ := path.Match(pattern, path.Base(name)); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
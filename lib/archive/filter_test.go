@@ -0,0 +1,92 @@
+// goPacked - A simple text-based Minecraft modpack manager.
+// Copyright (C) 2019 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package archive
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFilterApply(t *testing.T) {
+	tests := []struct {
+		name     string
+		filter   *Filter
+		entry    string
+		expected string
+		skipped  bool
+	}{
+		{"nil filter", nil, "./config\\a.cfg", "config/a.cfg", false},
+		{"empty filter", &Filter{}, "config/a.cfg", "config/a.cfg", false},
+		{"directory", &Filter{}, "config/", "config/", false},
+		{"strip components", &Filter{StripComponents: 1}, "pack-1.0/config/a.cfg", "config/a.cfg", false},
+		{"strip whole name", &Filter{StripComponents: 1}, "pack-1.0/", "", true},
+		{"strip too much", &Filter{StripComponents: 2}, "pack-1.0/a.cfg", "", true},
+		{"sub-path", &Filter{SubPath: "overrides/"}, "overrides/config/a.cfg", "config/a.cfg", false},
+		{"outside sub-path", &Filter{SubPath: "overrides"}, "manifest.json", "", true},
+		{"sub-path prefix", &Filter{SubPath: "overrides"}, "overrides2/a.cfg", "", true},
+		{"sub-path itself", &Filter{SubPath: "overrides"}, "overrides/", "", true},
+		{"strip then sub-path", &Filter{StripComponents: 1, SubPath: "overrides"}, "pack/overrides/a.cfg", "a.cfg", false},
+		{"include glob", &Filter{Include: []string{"config/*.cfg"}}, "config/a.cfg", "config/a.cfg", false},
+		{"not included", &Filter{Include: []string{"config/*.cfg"}}, "config/a.json", "", true},
+		{"included directory", &Filter{Include: []string{"config/*.cfg"}}, "scripts/", "scripts/", false},
+		{"include base name", &Filter{Include: []string{"*.cfg"}}, "config/sub/a.cfg", "config/sub/a.cfg", false},
+		{"include directory contents", &Filter{Include: []string{"config"}}, "config/sub/a.json", "config/sub/a.json", false},
+		{"exclude", &Filter{Exclude: []string{"*.bak"}}, "config/a.cfg.bak", "", true},
+		{"exclude directory", &Filter{Exclude: []string{"config/sub/"}}, "config/sub/a.cfg", "", true},
+		{"exclude overrides include", &Filter{Include: []string{"config"}, Exclude: []string{"config/b.cfg"}}, "config/b.cfg", "", true},
+		{"patterns after filtering", &Filter{StripComponents: 1, Include: []string{"config/*"}}, "pack/config/a.cfg", "config/a.cfg", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			name, ok := test.filter.Apply(test.entry)
+			if ok == test.skipped {
+				t.Errorf("expected skipped to be %t, got %t (%q)", test.skipped, !ok, name)
+			} else if ok && name != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, name)
+			}
+		})
+	}
+}
+
+func TestUnzipFilter(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	archive := filepath.Join(dir, "pack.zip")
+	makeZip(t, archive,
+		entry{name: "pack/manifest.json", content: "{}"},
+		entry{name: "pack/overrides/config/a.cfg", content: "a"},
+		entry{name: "pack/overrides/config/a.cfg.bak", content: "old"},
+		entry{name: "pack/overrides/mods/b.jar", content: "b"},
+	)
+	target := filepath.Join(dir, "target")
+	err := Unzip(archive, target, &Filter{StripComponents: 1, SubPath: "overrides", Exclude: []string{"*.bak"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for path, expected := range map[string]string{"config/a.cfg": "a", "mods/b.jar": "b"} {
+		if data, err := ioutil.ReadFile(filepath.Join(target, path)); err != nil || string(data) != expected {
+			t.Errorf("expected %s to contain %q, got %q, %v", path, expected, data, err)
+		}
+	}
+	for _, path := range []string{"manifest.json", "overrides", "pack", "config/a.cfg.bak"} {
+		if _, err := os.Stat(filepath.Join(target, path)); err == nil {
+			t.Errorf("%s shouldn't have been extracted", path)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+// goPacked - A simple text-based Minecraft modpack manager.
+// Copyright (C) 2019 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package gopacked
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Cache is a local directory of downloaded files shared between all goPacks. Files are keyed by their checksum or
+// by their URL and version if the file entry has no checksums. The least recently used files are evicted when the
+// cache grows past its size limit.
+type Cache struct {
+	Path    string
+	MaxSize int64
+
+	lock sync.Mutex
+}
+
+// CacheEntry contains the info of a single file in the cache.
+type CacheEntry struct {
+	Key      string
+	Size     int64
+	LastUsed time.Time
+}
+
+// NewCache creates a cache in the given directory with the given size limit in bytes.
+func NewCache(path string, maxSize int64) *Cache {
+	return &Cache{Path: path, MaxSize: maxSize}
+}
+
+// CacheKey returns the key of this file entry in the download cache. An empty string is returned if the file entry
+// has neither a valid checksum nor a version, as there's no way to know if the file behind the URL has changed.
+func (fe FileEntry) CacheKey() string {
+	if isHexChecksum(fe.SHA512, sha512.Size) {
+		return "sha512-" + strings.ToLower(fe.SHA512)
+	} else if isHexChecksum(fe.SHA256, sha256.Size) {
+		return "sha256-" + strings.ToLower(fe.SHA256)
+	} else if isHexChecksum(fe.SHA1, sha1.Size) {
+		return "sha1-" + strings.ToLower(fe.SHA1)
+	} else if urls := fe.URLs(); len(fe.Version) != 0 && len(urls) != 0 {
+		hash := sha256.Sum256([]byte(urls[0] + "\n" + fe.Version.String()))
+		return "url-" + hex.EncodeToString(hash[:])
+	}
+	return ""
+}
+
+// isHexChecksum checks whether the given string is a hex-encoded checksum of the given size. Checksums come from the
+// definition and are used as file names in the cache, so anything else must not be used as a key.
+func isHexChecksum(checksum string, size int) bool {
+	if len(checksum) != size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(checksum)
+	return err == nil
+}
+
+// Get copies the cached file with the given key to the given path. Returns false if the file isn't in the cache.
+func (cache *Cache) Get(key, path string) bool {
+	if cache == nil || len(key) == 0 {
+		return false
+	}
+	cachePath := filepath.Join(cache.Path, key)
+	err := copyFile(cachePath, path)
+	if err != nil {
+		return false
+	}
+	now := time.Now()
+	_ = os.Chtimes(cachePath, now, now)
+	return true
+}
+
+// Put copies the file at the given path into the cache with the given key and evicts old files if necessary.
+func (cache *Cache) Put(key, path string) error {
+	if cache == nil || len(key) == 0 || cache.MaxSize <= 0 {
+		return nil
+	}
+	err := os.MkdirAll(cache.Path, 0755)
+	if err != nil {
+		return err
+	}
+	temp, err := ioutil.TempFile(cache.Path, ".tmp-")
+	if err != nil {
+		return err
+	}
+	tempPath := temp.Name()
+	_ = temp.Close()
+	err = copyFile(path, tempPath)
+	if err == nil {
+		err = os.Rename(tempPath, filepath.Join(cache.Path, key))
+	}
+	if err != nil {
+		_ = os.Remove(tempPath)
+		return err
+	}
+	_, _, err = cache.Prune()
+	return err
+}
+
+// List returns all the files in the cache, most recently used first.
+func (cache *Cache) List() ([]CacheEntry, error) {
+	files, err := ioutil.ReadDir(cache.Path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	entries := make([]CacheEntry, 0, len(files))
+	for _, file := range files {
+		if file.IsDir() || strings.HasPrefix(file.Name(), ".") {
+			continue
+		}
+		entries = append(entries, CacheEntry{Key: file.Name(), Size: file.Size(), LastUsed: file.ModTime()})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].LastUsed.After(entries[j].LastUsed)
+	})
+	return entries, nil
+}
+
+// Prune removes the least recently used files from the cache until it fits in the size limit.
+// Returns the number of files removed and the number of bytes freed.
+func (cache *Cache) Prune() (removed int, freed int64, err error) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	entries, err := cache.List()
+	if err != nil {
+		return
+	}
+	var size int64
+	for _, entry := range entries {
+		size += entry.Size
+	}
+	for i := len(entries) - 1; i >= 0 && size > cache.MaxSize; i-- {
+		err = os.Remove(filepath.Join(cache.Path, entries[i].Key))
+		if err != nil {
+			return
+		}
+		size -= entries[i].Size
+		freed += entries[i].Size
+		removed++
+	}
+	return
+}
+
+// Clear removes all files from the cache.
+func (cache *Cache) Clear() error {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	return os.RemoveAll(cache.Path)
+}
+
+func copyFile(from, to string) error {
+	in, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	err = os.MkdirAll(filepath.Dir(to), 0755)
+	if err != nil {
+		return err
+	}
+	out, err := os.Create(to)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	closeErr := out.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
@@ -0,0 +1,52 @@
+// goPacked - A simple text-based Minecraft modpack manager.
+// Copyright (C) 2019 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package gopacked
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCacheKey(t *testing.T) {
+	sha1Sum := strings.Repeat("ab", 20)
+	sha256Sum := strings.Repeat("CD", 32)
+	tests := []struct {
+		name     string
+		entry    FileEntry
+		expected string
+	}{
+		{"sha1", FileEntry{SHA1: sha1Sum}, "sha1-" + sha1Sum},
+		{"sha256 is preferred", FileEntry{SHA1: sha1Sum, SHA256: sha256Sum}, "sha256-" + strings.ToLower(sha256Sum)},
+		{"invalid sha256 is skipped", FileEntry{SHA1: sha1Sum, SHA256: "../../x"}, "sha1-" + sha1Sum},
+		{"path traversal", FileEntry{SHA512: "../../../etc/passwd"}, ""},
+		{"wrong length", FileEntry{SHA1: sha1Sum + "ab"}, ""},
+		{"no version", FileEntry{URL: "http://example.com/a.jar"}, ""},
+		{"no url", FileEntry{Version: "1"}, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if key := test.entry.CacheKey(); key != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, key)
+			}
+		})
+	}
+
+	key := FileEntry{URL: "http://example.com/a.jar", Version: "1", SHA256: "../x"}.CacheKey()
+	if !strings.HasPrefix(key, "url-") || len(key) != len("url-")+64 {
+		t.Errorf("invalid checksum didn't fall back to the url key: %q", key)
+	}
+}
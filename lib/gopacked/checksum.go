@@ -0,0 +1,93 @@
+// goPacked - A simple text-based Minecraft modpack manager.
+// Copyright (C) 2019 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package gopacked
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+)
+
+// ChecksumError is returned when the checksum of a downloaded file doesn't match the one in the file entry.
+type ChecksumError struct {
+	Algorithm string
+	Expected  string
+	Actual    string
+}
+
+func (err ChecksumError) Error() string {
+	return fmt.Sprintf("%s checksum mismatch: expected %s, got %s", err.Algorithm, err.Expected, err.Actual)
+}
+
+// HasChecksum checks whether or not this file entry contains any checksums.
+func (fe FileEntry) HasChecksum() bool {
+	return len(fe.SHA1) != 0 || len(fe.SHA256) != 0 || len(fe.SHA512) != 0
+}
+
+// VerifyChecksum checks the file at the given path against all the checksums in this file entry.
+func (fe FileEntry) VerifyChecksum(path string) error {
+	if !fe.HasChecksum() {
+		return nil
+	}
+
+	var algorithms []string
+	var hashes []hash.Hash
+	var expected []string
+	if len(fe.SHA1) != 0 {
+		algorithms = append(algorithms, "sha1")
+		hashes = append(hashes, sha1.New())
+		expected = append(expected, fe.SHA1)
+	}
+	if len(fe.SHA256) != 0 {
+		algorithms = append(algorithms, "sha256")
+		hashes = append(hashes, sha256.New())
+		expected = append(expected, fe.SHA256)
+	}
+	if len(fe.SHA512) != 0 {
+		algorithms = append(algorithms, "sha512")
+		hashes = append(hashes, sha512.New())
+		expected = append(expected, fe.SHA512)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	writers := make([]io.Writer, len(hashes))
+	for i, h := range hashes {
+		writers[i] = h
+	}
+	_, err = io.Copy(io.MultiWriter(writers...), file)
+	if err != nil {
+		return err
+	}
+
+	for i, h := range hashes {
+		actual := hex.EncodeToString(h.Sum(nil))
+		if !strings.EqualFold(actual, expected[i]) {
+			return ChecksumError{Algorithm: algorithms[i], Expected: strings.ToLower(expected[i]), Actual: actual}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+// goPacked - A simple text-based Minecraft modpack manager.
+// Copyright (C) 2019 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package gopacked
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	helloSHA1   = "aaf4c61ddcc5e8a2dabede0f3b482cd9aea9434d"
+	helloSHA256 = "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+	helloSHA512 = "9b71d224bd62f3785d96d46ad3ea3d73319bfbc2890caadae2dff72519673ca72323c3d99ba5c11d7c7acc6e14b8c5da0c4663475c2e5c3adef46f73bcdec043"
+)
+
+func TestVerifyChecksum(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "hello.txt")
+	if err := ioutil.WriteFile(path, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		entry     FileEntry
+		algorithm string
+	}{
+		{"no checksum", FileEntry{}, ""},
+		{"sha1", FileEntry{SHA1: helloSHA1}, ""},
+		{"sha256", FileEntry{SHA256: helloSHA256}, ""},
+		{"sha512", FileEntry{SHA512: helloSHA512}, ""},
+		{"uppercase", FileEntry{SHA256: strings.ToUpper(helloSHA256)}, ""},
+		{"all algorithms", FileEntry{SHA1: helloSHA1, SHA256: helloSHA256, SHA512: helloSHA512}, ""},
+		{"wrong sha1", FileEntry{SHA1: helloSHA256[:40]}, "sha1"},
+		{"wrong sha256", FileEntry{SHA256: strings.Repeat("0", 64)}, "sha256"},
+		{"wrong sha512 with correct sha1", FileEntry{SHA1: helloSHA1, SHA512: helloSHA256}, "sha512"},
+		{"not hex", FileEntry{SHA256: "not a checksum"}, "sha256"},
+		{"wrong algorithm", FileEntry{SHA1: helloSHA256}, "sha1"},
+	}
+	for _, test := range tests {
+		err := test.entry.VerifyChecksum(path)
+		if len(test.algorithm) == 0 {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %s", test.name, err)
+			}
+			continue
+		}
+		checksumErr, ok := err.(ChecksumError)
+		if !ok {
+			t.Errorf("%s: expected a checksum error, got %v", test.name, err)
+		} else if checksumErr.Algorithm != test.algorithm {
+			t.Errorf("%s: expected a %s mismatch, got %s", test.name, test.algorithm, checksumErr)
+		}
+	}
+
+	if err := (FileEntry{SHA1: helloSHA1}).VerifyChecksum(filepath.Join(dir, "missing.txt")); !os.IsNotExist(err) {
+		t.Errorf("expected a not found error for a missing file, got %v", err)
+	}
+}
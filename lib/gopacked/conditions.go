@@ -0,0 +1,131 @@
+// goPacked - A simple text-based Minecraft modpack manager.
+// Copyright (C) 2019 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package gopacked
+
+import (
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+
+	"maunium.net/go/gopacked/lib/log"
+)
+
+// Conditions restricts a file entry to certain systems. Empty fields match all systems.
+type Conditions struct {
+	// OS is a list of operating systems, e.g. linux, windows or osx.
+	OS []string `json:"os,omitempty"`
+	// Arch is a list of CPU architectures, e.g. x86, x86_64 or arm64.
+	Arch []string `json:"arch,omitempty"`
+	// MinJavaVersion is the minimum major version of the java command, e.g. 8 or 17.
+	MinJavaVersion int `json:"java-version-minimum,omitempty"`
+	// MinMemory is the minimum amount of physical memory in megabytes.
+	MinMemory int `json:"memory-minimum,omitempty"`
+}
+
+var osAliases = map[string]string{
+	"osx":   "darwin",
+	"macos": "darwin",
+	"mac":   "darwin",
+}
+
+var archAliases = map[string]string{
+	"x86":     "386",
+	"i386":    "386",
+	"i686":    "386",
+	"x86_64":  "amd64",
+	"x64":     "amd64",
+	"aarch64": "arm64",
+}
+
+func matchAny(values []string, current string, aliases map[string]string) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, value := range values {
+		value = strings.ToLower(value)
+		if alias, ok := aliases[value]; ok {
+			value = alias
+		}
+		if value == current {
+			return true
+		}
+	}
+	return false
+}
+
+// Match checks whether the current system matches the conditions. Conditions that can't be checked because the
+// Java version or the amount of memory couldn't be detected are considered to match.
+func (cond *Conditions) Match() bool {
+	if cond == nil {
+		return true
+	} else if !matchAny(cond.OS, runtime.GOOS, osAliases) || !matchAny(cond.Arch, runtime.GOARCH, archAliases) {
+		return false
+	}
+	if cond.MinJavaVersion > 0 {
+		if version := JavaVersion(); version > 0 && version < cond.MinJavaVersion {
+			return false
+		}
+	}
+	if cond.MinMemory > 0 {
+		if memory := TotalMemory(); memory > 0 && memory < int64(cond.MinMemory)*1024*1024 {
+			return false
+		}
+	}
+	return true
+}
+
+var javaVersion int
+var javaVersionOnce sync.Once
+var javaVersionRegex = regexp.MustCompile(`version "(?:1\.)?(\d+)`)
+
+// JavaVersion returns the major version of the java command in PATH, or 0 if it couldn't be detected.
+func JavaVersion() int {
+	javaVersionOnce.Do(func() {
+		// java -version prints to stderr.
+		output, err := exec.Command("java", "-version").CombinedOutput()
+		if err != nil {
+			log.Warnf("Failed to detect Java version, ignoring Java version conditions: %s", err)
+			return
+		}
+		match := javaVersionRegex.FindSubmatch(output)
+		if match == nil {
+			log.Warnf("Failed to detect Java version, ignoring Java version conditions")
+			return
+		}
+		javaVersion, _ = strconv.Atoi(string(match[1]))
+	})
+	return javaVersion
+}
+
+var totalMemory int64
+var totalMemoryOnce sync.Once
+
+// TotalMemory returns the amount of physical memory in bytes, or 0 if it couldn't be detected.
+func TotalMemory() int64 {
+	totalMemoryOnce.Do(func() {
+		var err error
+		totalMemory, err = detectMemory()
+		if err != nil {
+			log.Warnf("Failed to detect the amount of memory, ignoring memory conditions: %s", err)
+			totalMemory = 0
+		}
+	})
+	return totalMemory
+}
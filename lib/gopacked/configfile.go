@@ -0,0 +1,510 @@
+// goPacked - A simple text-based Minecraft modpack manager.
+// Copyright (C) 2019 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package gopacked
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// configFile is a config file that can be edited key by key. The values are in the representation returned by
+// PatchFormat.encodeValue, i.e. decoded JSON values for JSON files and raw strings for the text-based formats.
+type configFile interface {
+	Get(key string) (interface{}, bool)
+	Set(key string, value interface{}) error
+	Unset(key string)
+	Save(path string) error
+}
+
+// loadConfigFile reads the config file at the given path. Files that don't exist are treated as empty.
+func loadConfigFile(format PatchFormat, path string) (configFile, error) {
+	if format == FormatJSON {
+		data, err := readJSON(path)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		} else if data == nil {
+			data = make(map[string]interface{})
+		}
+		return &jsonConfig{data}, nil
+	}
+	text, err := readTextConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	switch format {
+	case FormatTOML:
+		return &tomlConfig{text}, nil
+	case FormatProperties:
+		return &propertiesConfig{text}, nil
+	case FormatForgeConfig:
+		return &forgeConfig{text}, nil
+	default:
+		return nil, fmt.Errorf("unknown patch format %s", format)
+	}
+}
+
+// encodeValue converts a value from a patch entry into the representation used by configFiles of this format.
+func (format PatchFormat) encodeValue(value interface{}) (interface{}, error) {
+	switch format {
+	case FormatJSON:
+		return value, nil
+	case FormatTOML:
+		return encodeTOMLValue(value)
+	default:
+		switch val := value.(type) {
+		case string:
+			return val, nil
+		case bool:
+			return strconv.FormatBool(val), nil
+		case float64:
+			return strconv.FormatFloat(val, 'f', -1, 64), nil
+		default:
+			return nil, fmt.Errorf("unsupported value type %T", value)
+		}
+	}
+}
+
+func encodeTOMLValue(value interface{}) (string, error) {
+	switch val := value.(type) {
+	case string:
+		return strconv.Quote(val), nil
+	case bool:
+		return strconv.FormatBool(val), nil
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64), nil
+	case []interface{}:
+		items := make([]string, len(val))
+		for i, item := range val {
+			var err error
+			items[i], err = encodeTOMLValue(item)
+			if err != nil {
+				return "", err
+			}
+		}
+		return "[" + strings.Join(items, ", ") + "]", nil
+	case map[string]interface{}:
+		keys := make([]string, 0, len(val))
+		for key := range val {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		items := make([]string, len(keys))
+		for i, key := range keys {
+			item, err := encodeTOMLValue(val[key])
+			if err != nil {
+				return "", err
+			}
+			items[i] = strconv.Quote(key) + " = " + item
+		}
+		return "{ " + strings.Join(items, ", ") + " }", nil
+	default:
+		return "", fmt.Errorf("unsupported value type %T", value)
+	}
+}
+
+// jsonConfig is a JSON config file. Keys are dot-separated paths to values in nested objects.
+type jsonConfig struct {
+	data map[string]interface{}
+}
+
+func (cfg *jsonConfig) Get(key string) (interface{}, bool) {
+	parts := strings.Split(key, ".")
+	obj := cfg.data
+	for _, part := range parts[:len(parts)-1] {
+		var ok bool
+		obj, ok = obj[part].(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+	}
+	value, ok := obj[parts[len(parts)-1]]
+	return value, ok
+}
+
+func (cfg *jsonConfig) Set(key string, value interface{}) error {
+	parts := strings.Split(key, ".")
+	obj := cfg.data
+	for i, part := range parts[:len(parts)-1] {
+		child, ok := obj[part]
+		if !ok {
+			child = make(map[string]interface{})
+			obj[part] = child
+		}
+		obj, ok = child.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s is not an object", strings.Join(parts[:i+1], "."))
+		}
+	}
+	obj[parts[len(parts)-1]] = value
+	return nil
+}
+
+func (cfg *jsonConfig) Unset(key string) {
+	parts := strings.Split(key, ".")
+	obj := cfg.data
+	for _, part := range parts[:len(parts)-1] {
+		var ok bool
+		obj, ok = obj[part].(map[string]interface{})
+		if !ok {
+			return
+		}
+	}
+	delete(obj, parts[len(parts)-1])
+}
+
+func (cfg *jsonConfig) Save(path string) error {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	return writeJSON(cfg.data, path)
+}
+
+// textConfig contains the lines of a line-based config file.
+type textConfig struct {
+	lines []string
+	crlf  bool
+}
+
+func readTextConfig(path string) (textConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return textConfig{}, nil
+	} else if err != nil {
+		return textConfig{}, err
+	}
+	text := string(data)
+	crlf := strings.Contains(text, "\r\n")
+	text = strings.TrimSuffix(strings.Replace(text, "\r\n", "\n", -1), "\n")
+	if len(text) == 0 {
+		return textConfig{crlf: crlf}, nil
+	}
+	return textConfig{lines: strings.Split(text, "\n"), crlf: crlf}, nil
+}
+
+func (cfg *textConfig) Save(path string) error {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	newline := "\n"
+	if cfg.crlf {
+		newline = "\r\n"
+	}
+	text := strings.Join(cfg.lines, newline)
+	if len(cfg.lines) > 0 {
+		text += newline
+	}
+	return ioutil.WriteFile(path, []byte(text), 0644)
+}
+
+func (cfg *textConfig) insert(index int, lines ...string) {
+	cfg.lines = append(cfg.lines[:index], append(lines, cfg.lines[index:]...)...)
+}
+
+func (cfg *textConfig) remove(index int) {
+	cfg.lines = append(cfg.lines[:index], cfg.lines[index+1:]...)
+}
+
+// propertiesConfig is a Java .properties file, like server.properties. The key-value separator used in the file
+// (= or :) is preserved, so it also works for files like options.txt.
+type propertiesConfig struct {
+	textConfig
+}
+
+// parsePropertiesLine returns the key of the given line and the index where its value starts,
+// or -1 if the line is empty or a comment.
+func parsePropertiesLine(line string) (string, int) {
+	start := len(line) - len(strings.TrimLeft(line, " \t\f"))
+	if start == len(line) || line[start] == '#' || line[start] == '!' {
+		return "", -1
+	}
+	end := strings.IndexAny(line[start:], "=: \t\f")
+	if end < 0 {
+		return line[start:], len(line)
+	}
+	end += start
+	value := end
+	for value < len(line) && strings.ContainsRune(" \t\f", rune(line[value])) {
+		value++
+	}
+	if value < len(line) && (line[value] == '=' || line[value] == ':') {
+		value++
+	}
+	for value < len(line) && strings.ContainsRune(" \t\f", rune(line[value])) {
+		value++
+	}
+	return line[start:end], value
+}
+
+func (cfg *propertiesConfig) find(key string) (int, int) {
+	for i, line := range cfg.lines {
+		lineKey, value := parsePropertiesLine(line)
+		if value >= 0 && lineKey == key {
+			return i, value
+		}
+	}
+	return -1, -1
+}
+
+func (cfg *propertiesConfig) Get(key string) (interface{}, bool) {
+	i, value := cfg.find(key)
+	if i < 0 {
+		return nil, false
+	}
+	return cfg.lines[i][value:], true
+}
+
+func (cfg *propertiesConfig) Set(key string, value interface{}) error {
+	i, start := cfg.find(key)
+	if i >= 0 {
+		cfg.lines[i] = cfg.lines[i][:start] + value.(string)
+		return nil
+	}
+	separator := "="
+	for _, line := range cfg.lines {
+		if lineKey, start := parsePropertiesLine(line); start > len(lineKey) {
+			separator = strings.TrimSpace(line[len(lineKey):start])
+			break
+		}
+	}
+	cfg.lines = append(cfg.lines, key+separator+value.(string))
+	return nil
+}
+
+func (cfg *propertiesConfig) Unset(key string) {
+	if i, _ := cfg.find(key); i >= 0 {
+		cfg.remove(i)
+	}
+}
+
+// tomlConfig is a TOML file. Keys are dot-separated, and everything before the last dot is the name of the table.
+// Only single-line values are supported.
+type tomlConfig struct {
+	textConfig
+}
+
+func normalizeTOMLKey(key string) string {
+	parts := strings.Split(key, ".")
+	for i, part := range parts {
+		parts[i] = strings.Trim(strings.TrimSpace(part), `"'`)
+	}
+	return strings.Join(parts, ".")
+}
+
+// find finds the line of the given key in the given table. If the key isn't found, the returned index is -1 and
+// insertAt is the index where the key should be added, or -1 if the table doesn't exist.
+func (cfg *tomlConfig) find(table, name string) (index, value, insertAt int) {
+	current := ""
+	insertAt = -1
+	if len(table) == 0 {
+		insertAt = 0
+	}
+	for i, line := range cfg.lines {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "[") {
+			end := strings.Index(trimmed, "]")
+			if end < 0 {
+				continue
+			}
+			current = normalizeTOMLKey(strings.Trim(trimmed[:end], "[]"))
+			if current == table {
+				insertAt = i + 1
+			}
+			continue
+		} else if current != table || len(trimmed) == 0 || trimmed[0] == '#' {
+			continue
+		}
+		insertAt = i + 1
+		eq := strings.Index(line, "=")
+		if eq < 0 || normalizeTOMLKey(line[:eq]) != name {
+			continue
+		}
+		value = eq + 1
+		for value < len(line) && (line[value] == ' ' || line[value] == '\t') {
+			value++
+		}
+		return i, value, insertAt
+	}
+	return -1, -1, insertAt
+}
+
+func splitLastDot(key string) (string, string) {
+	dot := strings.LastIndex(key, ".")
+	if dot < 0 {
+		return "", key
+	}
+	return key[:dot], key[dot+1:]
+}
+
+func (cfg *tomlConfig) Get(key string) (interface{}, bool) {
+	table, name := splitLastDot(key)
+	i, value, _ := cfg.find(table, name)
+	if i < 0 {
+		return nil, false
+	}
+	return cfg.lines[i][value:], true
+}
+
+func (cfg *tomlConfig) Set(key string, value interface{}) error {
+	table, name := splitLastDot(key)
+	i, start, insertAt := cfg.find(table, name)
+	if i >= 0 {
+		cfg.lines[i] = cfg.lines[i][:start] + value.(string)
+		return nil
+	}
+	line := name + " = " + value.(string)
+	if strings.ContainsAny(name, " .=\"") {
+		line = strconv.Quote(name) + " = " + value.(string)
+	}
+	if insertAt >= 0 {
+		cfg.insert(insertAt, line)
+		return nil
+	}
+	if len(cfg.lines) > 0 {
+		cfg.lines = append(cfg.lines, "")
+	}
+	cfg.lines = append(cfg.lines, "["+table+"]", line)
+	return nil
+}
+
+func (cfg *tomlConfig) Unset(key string) {
+	table, name := splitLastDot(key)
+	if i, _, _ := cfg.find(table, name); i >= 0 {
+		cfg.remove(i)
+	}
+}
+
+// forgeConfig is a Forge .cfg file, where keys are grouped into nested categories and prefixed with a type
+// (e.g. I:maxPlayers=20). Keys are dot-separated, and everything before the last dot is the category path.
+// List values are not supported.
+type forgeConfig struct {
+	textConfig
+}
+
+// find finds the line of the given key in the given category. If the key isn't found, the returned index is -1,
+// insertAt is the index where the key or the missing categories should be added, and depth is the number of
+// categories in the path that already exist.
+func (cfg *forgeConfig) find(categories []string, name string) (index, value, insertAt, depth int) {
+	var stack []string
+	inList := false
+	insertAt = len(cfg.lines)
+	for i, line := range cfg.lines {
+		trimmed := strings.TrimSpace(line)
+		if inList {
+			inList = trimmed != ">"
+			continue
+		} else if len(trimmed) == 0 || trimmed[0] == '#' {
+			continue
+		} else if strings.HasSuffix(trimmed, "{") {
+			stack = append(stack, strings.Trim(strings.TrimSpace(strings.TrimSuffix(trimmed, "{")), `"`))
+			continue
+		} else if trimmed == "}" {
+			if len(stack) <= len(categories) && len(stack) > depth && equalPrefix(stack, categories) {
+				// This closes the deepest category of the path found so far.
+				insertAt = i
+				depth = len(stack)
+			}
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+			continue
+		} else if strings.HasSuffix(trimmed, "<") {
+			inList = true
+			continue
+		}
+		eq := strings.Index(line, "=")
+		if eq < 0 || len(stack) != len(categories) || !equalPrefix(stack, categories) {
+			continue
+		}
+		key := strings.TrimSpace(line[:eq])
+		if colon := strings.Index(key, ":"); colon == 1 {
+			key = key[2:]
+		}
+		if strings.Trim(key, `"`) == name {
+			return i, eq + 1, -1, len(categories)
+		}
+	}
+	return -1, -1, insertAt, depth
+}
+
+func equalPrefix(stack, categories []string) bool {
+	for i, category := range stack {
+		if i >= len(categories) || categories[i] != category {
+			return false
+		}
+	}
+	return true
+}
+
+func forgeTypePrefix(value string) string {
+	if value == "true" || value == "false" {
+		return "B"
+	} else if _, err := strconv.Atoi(value); err == nil {
+		return "I"
+	} else if _, err := strconv.ParseFloat(value, 64); err == nil {
+		return "D"
+	}
+	return "S"
+}
+
+func (cfg *forgeConfig) Get(key string) (interface{}, bool) {
+	category, name := splitLastDot(key)
+	i, value, _, _ := cfg.find(strings.Split(category, "."), name)
+	if i < 0 {
+		return nil, false
+	}
+	return cfg.lines[i][value:], true
+}
+
+func (cfg *forgeConfig) Set(key string, value interface{}) error {
+	category, name := splitLastDot(key)
+	if len(category) == 0 {
+		return fmt.Errorf("keys in Forge configs must be in a category")
+	}
+	categories := strings.Split(category, ".")
+	i, start, insertAt, depth := cfg.find(categories, name)
+	if i >= 0 {
+		cfg.lines[i] = cfg.lines[i][:start] + value.(string)
+		return nil
+	}
+	if strings.ContainsAny(name, " =") {
+		name = `"` + name + `"`
+	}
+	var lines []string
+	for j := depth; j < len(categories); j++ {
+		lines = append(lines, strings.Repeat("    ", j)+categories[j]+" {")
+	}
+	lines = append(lines, strings.Repeat("    ", len(categories))+forgeTypePrefix(value.(string))+":"+name+"="+value.(string))
+	for j := len(categories) - 1; j >= depth; j-- {
+		lines = append(lines, strings.Repeat("    ", j)+"}")
+	}
+	cfg.insert(insertAt, lines...)
+	return nil
+}
+
+func (cfg *forgeConfig) Unset(key string) {
+	category, name := splitLastDot(key)
+	if i, _, _, _ := cfg.find(strings.Split(category, "."), name); i >= 0 {
+		cfg.remove(i)
+	}
+}
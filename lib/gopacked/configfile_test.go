@@ -0,0 +1,151 @@
+// goPacked - A simple text-based Minecraft modpack manager.
+// Copyright (C) 2019 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package gopacked
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+type configTest struct {
+	name     string
+	input    string
+	set      map[string]interface{}
+	unset    []string
+	expected string
+}
+
+// runConfigTests patches the input of each test with the given format and compares the saved file to the expected
+// output. A nil input means that the file doesn't exist.
+func runConfigTests(t *testing.T, format PatchFormat, tests []configTest) {
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := tempDir(t)
+			defer os.RemoveAll(dir)
+			path := filepath.Join(dir, "config")
+			if len(test.input) != 0 {
+				if err := ioutil.WriteFile(path, []byte(test.input), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			cfg, err := loadConfigFile(format, path)
+			if err != nil {
+				t.Fatalf("failed to load config: %s", err)
+			}
+			for _, key := range test.unset {
+				cfg.Unset(key)
+			}
+			for key, value := range test.set {
+				encoded, err := format.encodeValue(value)
+				if err != nil {
+					t.Fatalf("failed to encode %v: %s", value, err)
+				} else if err = cfg.Set(key, encoded); err != nil {
+					t.Fatalf("failed to set %s: %s", key, err)
+				} else if current, ok := cfg.Get(key); !ok || !reflect.DeepEqual(current, encoded) {
+					t.Errorf("expected %s to be %v after setting it, got %v", key, encoded, current)
+				}
+			}
+			if err = cfg.Save(path); err != nil {
+				t.Fatalf("failed to save config: %s", err)
+			}
+			if output := readString(t, path); output != test.expected {
+				t.Errorf("unexpected output:\n%s\nexpected:\n%s", output, test.expected)
+			}
+		})
+	}
+}
+
+func TestPropertiesConfig(t *testing.T) {
+	runConfigTests(t, FormatProperties, []configTest{
+		{"replace value", "# comment\nmax-players=20\nmotd=Hello\n",
+			map[string]interface{}{"max-players": float64(30)}, nil,
+			"# comment\nmax-players=30\nmotd=Hello\n"},
+		{"add key with file separator", "fov:70\nrenderDistance:8\n",
+			map[string]interface{}{"gamma": "1.0", "renderDistance": float64(12)}, nil,
+			"fov:70\nrenderDistance:12\ngamma:1.0\n"},
+		{"unset", "a=1\nb=2\nc=3\n", nil, []string{"b", "missing"}, "a=1\nc=3\n"},
+		{"spaces around separator", "key = old\n", map[string]interface{}{"key": true}, nil, "key = true\n"},
+		{"crlf", "a=1\r\nb=2\r\n", map[string]interface{}{"c": "3"}, nil, "a=1\r\nb=2\r\nc=3\r\n"},
+		{"new file", "", map[string]interface{}{"online-mode": false}, nil, "online-mode=false\n"},
+	})
+}
+
+func TestTOMLConfig(t *testing.T) {
+	runConfigTests(t, FormatTOML, []configTest{
+		{"replace value", "[client]\nfov = 70 # comment\nname = \"x\"\n",
+			map[string]interface{}{"client.name": "y"}, nil,
+			"[client]\nfov = 70 # comment\nname = \"y\"\n"},
+		{"add key to table", "root = 1\n\n[client]\nfov = 70\n\n[server]\nport = 1\n",
+			map[string]interface{}{"client.gui": true}, nil,
+			"root = 1\n\n[client]\nfov = 70\ngui = true\n\n[server]\nport = 1\n"},
+		{"add root key", "[client]\nfov = 70\n", map[string]interface{}{"enabled": true}, nil,
+			"enabled = true\n[client]\nfov = 70\n"},
+		{"add table", "[client]\nfov = 70\n", map[string]interface{}{"server.list": []interface{}{float64(1), "a"}}, nil,
+			"[client]\nfov = 70\n\n[server]\nlist = [1, \"a\"]\n"},
+		{"quoted table and key", "[\"my mod\"]\n\"key\" = 1\n", map[string]interface{}{"my mod.key": float64(2)}, nil,
+			"[\"my mod\"]\n\"key\" = 2\n"},
+		{"unset", "[server]\nport = 1\nhost = \"x\"\n", nil, []string{"server.port"}, "[server]\nhost = \"x\"\n"},
+		{"inline table", "", map[string]interface{}{"a.b": map[string]interface{}{"y": "2", "x": float64(1)}}, nil,
+			"[a]\nb = { \"x\" = 1, \"y\" = \"2\" }\n"},
+	})
+}
+
+func TestForgeConfig(t *testing.T) {
+	input := "# Configuration file\n\ngeneral {\n    B:enabled=false\n    S:name=x\n\n    nested {\n        I:count=1\n    }\n\n}\n\n" +
+		"lists {\n    S:items <\n        a=b\n     >\n}\n"
+	runConfigTests(t, FormatForgeConfig, []configTest{
+		{"replace value", input, map[string]interface{}{"general.enabled": true, "general.nested.count": float64(5)}, nil,
+			"# Configuration file\n\ngeneral {\n    B:enabled=true\n    S:name=x\n\n    nested {\n        I:count=5\n    }\n\n}\n\n" +
+				"lists {\n    S:items <\n        a=b\n     >\n}\n"},
+		{"add key to category", input, map[string]interface{}{"general.nested.ratio": float64(0.5)}, nil,
+			"# Configuration file\n\ngeneral {\n    B:enabled=false\n    S:name=x\n\n    nested {\n        I:count=1\n        D:ratio=0.5\n    }\n\n}\n\n" +
+				"lists {\n    S:items <\n        a=b\n     >\n}\n"},
+		{"list items aren't keys", input, map[string]interface{}{"lists.a": "c"}, nil,
+			"# Configuration file\n\ngeneral {\n    B:enabled=false\n    S:name=x\n\n    nested {\n        I:count=1\n    }\n\n}\n\n" +
+				"lists {\n    S:items <\n        a=b\n     >\n    S:a=c\n}\n"},
+		{"add categories", "general {\n    B:enabled=false\n}\n", map[string]interface{}{"general.client.hud.my name": "hi there"}, nil,
+			"general {\n    B:enabled=false\n    client {\n        hud {\n            S:\"my name\"=hi there\n        }\n    }\n}\n"},
+		{"unset", "general {\n    B:enabled=false\n    S:name=x\n}\n", nil, []string{"general.name"},
+			"general {\n    B:enabled=false\n}\n"},
+	})
+}
+
+func TestJSONConfig(t *testing.T) {
+	runConfigTests(t, FormatJSON, []configTest{
+		{"nested keys", `{"a": {"b": 1, "c": 2}, "d": 3}`,
+			map[string]interface{}{"a.b": float64(5), "e.f": "x"}, []string{"d", "a.c", "missing.key"},
+			`{"a":{"b":5},"e":{"f":"x"}}`},
+		{"new file", "", map[string]interface{}{"a": []interface{}{true}}, nil, `{"a":[true]}`},
+	})
+}
+
+func TestConfigErrors(t *testing.T) {
+	json := &jsonConfig{map[string]interface{}{"a": float64(1)}}
+	if err := json.Set("a.b", float64(2)); err == nil {
+		t.Error("expected setting a key inside a number to fail")
+	}
+	forge := &forgeConfig{}
+	if err := forge.Set("key", "value"); err == nil {
+		t.Error("expected setting a key without a category in a Forge config to fail")
+	}
+	if _, err := FormatProperties.encodeValue([]interface{}{"a"}); err == nil {
+		t.Error("expected encoding a list for a properties file to fail")
+	}
+}
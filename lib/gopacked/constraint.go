@@ -0,0 +1,136 @@
+// goPacked - A simple text-based Minecraft modpack manager.
+// Copyright (C) 2019 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package gopacked
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Operator is a comparison operator in a version constraint.
+type Operator string
+
+const (
+	OpEqual          Operator = "="
+	OpNotEqual       Operator = "!="
+	OpGreater        Operator = ">"
+	OpGreaterOrEqual Operator = ">="
+	OpSmaller        Operator = "<"
+	OpSmallerOrEqual Operator = "<="
+)
+
+// operators are the supported operators, two-character ones first so that they're matched before their prefixes.
+var operators = []Operator{OpGreaterOrEqual, OpSmallerOrEqual, OpNotEqual, OpGreater, OpSmaller, OpEqual}
+
+// ConstraintTerm is a single comparison in a version constraint, e.g. >=0.4.
+type ConstraintTerm struct {
+	Operator Operator
+	Version  Version
+}
+
+// Match checks whether the given version satisfies this term.
+func (term ConstraintTerm) Match(ver Version) bool {
+	compare := ver.Compare(term.Version)
+	switch term.Operator {
+	case OpNotEqual:
+		return compare != 0
+	case OpGreater:
+		return compare > 0
+	case OpGreaterOrEqual:
+		return compare >= 0
+	case OpSmaller:
+		return compare < 0
+	case OpSmallerOrEqual:
+		return compare <= 0
+	default:
+		return compare == 0
+	}
+}
+
+func (term ConstraintTerm) String() string {
+	return string(term.Operator) + string(term.Version)
+}
+
+// Constraint is a version range consisting of terms that must all match, e.g. ">=0.4 <0.6". The terms are separated
+// by spaces or commas. A version without an operator must be matched exactly. The empty constraint matches everything.
+type Constraint []ConstraintTerm
+
+// ParseConstraint parses a version constraint from a string.
+func ParseConstraint(str string) (Constraint, error) {
+	fields := strings.FieldsFunc(str, func(r rune) bool {
+		return r == ' ' || r == ','
+	})
+	constraint := make(Constraint, 0, len(fields))
+	for i := 0; i < len(fields); i++ {
+		field := fields[i]
+		op := OpEqual
+		for _, candidate := range operators {
+			if strings.HasPrefix(field, string(candidate)) {
+				op = candidate
+				field = field[len(op):]
+				break
+			}
+		}
+		// Allow a space between the operator and the version, e.g. ">= 0.4".
+		if len(field) == 0 && i+1 < len(fields) {
+			i++
+			field = fields[i]
+		}
+		if len(field) == 0 {
+			return nil, fmt.Errorf("missing version after %s in %s", op, str)
+		}
+		ver, err := ParseVersion(field)
+		if err != nil {
+			return nil, err
+		}
+		constraint = append(constraint, ConstraintTerm{op, ver})
+	}
+	return constraint, nil
+}
+
+// Match checks whether the given version satisfies all the terms of this constraint.
+func (constraint Constraint) Match(ver Version) bool {
+	for _, term := range constraint {
+		if !term.Match(ver) {
+			return false
+		}
+	}
+	return true
+}
+
+func (constraint Constraint) String() string {
+	terms := make([]string, len(constraint))
+	for i, term := range constraint {
+		terms[i] = term.String()
+	}
+	return strings.Join(terms, " ")
+}
+
+func (constraint *Constraint) UnmarshalJSON(blob []byte) error {
+	var data string
+	err := json.Unmarshal(blob, &data)
+	if err != nil {
+		return err
+	}
+	*constraint, err = ParseConstraint(data)
+	return err
+}
+
+func (constraint Constraint) MarshalJSON() ([]byte, error) {
+	return json.Marshal(constraint.String())
+}
@@ -0,0 +1,96 @@
+// goPacked - A simple text-based Minecraft modpack manager.
+// Copyright (C) 2019 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package gopacked
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseConstraint(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"", ""},
+		{">=0.4 <0.6", ">=0.4 <0.6"},
+		{">= 0.4, < 0.6", ">=0.4 <0.6"},
+		{"1.2.3", "=1.2.3"},
+		{"!=1.0-beta <=2", "!=1.0-beta <=2"},
+		{">01.0", ">1.0"},
+	}
+	for _, test := range tests {
+		constraint, err := ParseConstraint(test.input)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %s", test.input, err)
+		} else if constraint.String() != test.expected {
+			t.Errorf("%q: expected %q, got %q", test.input, test.expected, constraint.String())
+		}
+	}
+
+	for _, input := range []string{">=", ">=a", "0.4 <", "=>0.4", "~1.0"} {
+		if constraint, err := ParseConstraint(input); err == nil {
+			t.Errorf("expected %q to be invalid, got %q", input, constraint)
+		}
+	}
+}
+
+func TestConstraintMatch(t *testing.T) {
+	tests := []struct {
+		constraint string
+		matches    []Version
+		rejects    []Version
+	}{
+		{"", []Version{"", "0.1", "99"}, nil},
+		{">=0.4 <0.6", []Version{"0.4", "0.4.0.0", "0.5.9", "0.6-beta"}, []Version{"0.3.9", "0.4-rc.1", "0.6", "1"}},
+		{">0.4", []Version{"0.4.1", "1"}, []Version{"0.4", "0.4.0+build", "0.4-beta"}},
+		{"<=1.0", []Version{"1", "1.0+build", "0.9"}, []Version{"1.0.1"}},
+		{"1.2", []Version{"1.2", "1.2.0"}, []Version{"1.2.1", "1.2-beta"}},
+		{"!=1.2", []Version{"1.2.1", "1.2-beta"}, []Version{"1.2.0"}},
+		{">=1.0-alpha <1.0", []Version{"1.0-alpha", "1.0-beta.2"}, []Version{"0.9", "1.0"}},
+	}
+	for _, test := range tests {
+		constraint, err := ParseConstraint(test.constraint)
+		if err != nil {
+			t.Fatalf("%q: unexpected error: %s", test.constraint, err)
+		}
+		for _, ver := range test.matches {
+			if !constraint.Match(ver) {
+				t.Errorf("expected %q to match %s", test.constraint, ver)
+			}
+		}
+		for _, ver := range test.rejects {
+			if constraint.Match(ver) {
+				t.Errorf("expected %q not to match %s", test.constraint, ver)
+			}
+		}
+	}
+}
+
+func TestGoPackedVersionMigration(t *testing.T) {
+	var gp GoPack
+	err := json.Unmarshal([]byte(`{"gopacked-version-minimum": "0.4", "gopacked-version-maximum": "0.6.2"}`), &gp)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if gp.GoPackedReq.String() != ">=0.4 <=0.6.2" {
+		t.Errorf("unexpected constraint %q", gp.GoPackedReq)
+	}
+	if !gp.GoPackedReq.Match("0.6.2") || gp.GoPackedReq.Match("0.6.3") {
+		t.Errorf("migrated constraint %q doesn't match like the old range", gp.GoPackedReq)
+	}
+}
@@ -0,0 +1,441 @@
+// goPacked - A simple text-based Minecraft modpack manager.
+// Copyright (C) 2019 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package gopacked
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"maunium.net/go/gopacked/lib/log"
+)
+
+// DownloadTask is a single file download planned from a FileEntry tree.
+type DownloadTask struct {
+	// Name is the display name of the file entry.
+	Name string
+	// Message is logged when the download starts.
+	Message string
+	// Entry is the file entry that is being downloaded.
+	Entry FileEntry
+	// Path is the path the file is downloaded to.
+	Path string
+	// Target is the final path of the file if it's different from Path, e.g. when the file is staged in a transaction.
+	Target string
+	// Dir is the directory an archive is extracted to. If set, the files in it are recorded in the install state
+	// instead of the downloaded file.
+	Dir string
+	// Files contains the slash-separated paths of the files extracted by a merge-mode archive, relative to Dir.
+	// If set, only these files are recorded instead of everything in Dir.
+	Files []string
+	// Finish is called after the file has been downloaded and verified, e.g. to extract archives.
+	Finish func() error
+	// Preserved contains install state records that override the recorded state of files that were
+	// preserved from the previous version according to the update policy of the file entry. Files with a nil
+	// record were added by the user and aren't recorded at all.
+	Preserved map[string]*FileState
+}
+
+// DownloadError is returned by Downloader.Run for each task that failed.
+type DownloadError struct {
+	Name string
+	Err  error
+}
+
+func (err DownloadError) Error() string {
+	return fmt.Sprintf("%s: %s", err.Name, err.Err)
+}
+
+// Downloader plans file downloads and then fetches them in parallel.
+type Downloader struct {
+	// Concurrency is the maximum number of downloads running at once.
+	Concurrency int
+	// PerHost is the maximum number of downloads running at once from a single host.
+	PerHost int
+	// Cache is the download cache to consult before downloading files. Can be nil.
+	Cache *Cache
+	// State is the install state where successfully downloaded files and failures are recorded. Can be nil.
+	State *InstallState
+	// AllowLocalFiles allows downloading files from file:// URLs. It must only be set for goPacks loaded from
+	// the local file system (see GoPack.IsLocal).
+	AllowLocalFiles bool
+
+	tasks   []*DownloadTask
+	patches []patchOp
+}
+
+// NewDownloader creates a new Downloader with the given concurrency limits.
+func NewDownloader(concurrency, perHost int) *Downloader {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	if perHost < 1 || perHost > concurrency {
+		perHost = concurrency
+	}
+	return &Downloader{
+		Concurrency: concurrency,
+		PerHost:     perHost,
+	}
+}
+
+// Add adds a task to the download plan.
+func (dl *Downloader) Add(task *DownloadTask) {
+	dl.tasks = append(dl.tasks, task)
+}
+
+// Pending returns the number of planned downloads.
+func (dl *Downloader) Pending() int {
+	return len(dl.tasks)
+}
+
+// Run downloads all planned files and returns the errors of the failed tasks. The plan is cleared afterwards.
+func (dl *Downloader) Run() []error {
+	tasks := dl.tasks
+	dl.tasks = nil
+
+	client := HTTPClient
+	if dl.AllowLocalFiles {
+		client = LocalHTTPClient
+	}
+
+	// Tasks are queued per host and only started when their host has a free slot, so that the files of one host
+	// don't keep the files of other hosts waiting. Tasks without a host (e.g. inline files) are only limited by
+	// the total concurrency.
+	queues := make(map[string][]*DownloadTask)
+	var hosts []string
+	for _, task := range tasks {
+		host := taskHost(task)
+		if _, ok := queues[host]; !ok {
+			hosts = append(hosts, host)
+		}
+		queues[host] = append(queues[host], task)
+	}
+
+	type result struct {
+		host string
+		task *DownloadTask
+		err  error
+	}
+	results := make(chan result)
+	active := make(map[string]int)
+	running := 0
+	var errors []error
+	for remaining := len(tasks); remaining > 0; remaining-- {
+		for started := true; started && running < dl.Concurrency; {
+			started = false
+			// Take turns between hosts, so that the free slots are shared fairly.
+			for _, host := range hosts {
+				if running >= dl.Concurrency {
+					break
+				} else if len(queues[host]) == 0 || (len(host) != 0 && active[host] >= dl.PerHost) {
+					continue
+				}
+				task := queues[host][0]
+				queues[host] = queues[host][1:]
+				active[host]++
+				running++
+				started = true
+				go func(host string, task *DownloadTask) {
+					err := task.run(dl.Cache, client)
+					if err == nil {
+						err = task.record(dl.State)
+					}
+					results <- result{host, task, err}
+				}(host, task)
+			}
+		}
+
+		res := <-results
+		active[res.host]--
+		running--
+		if res.err != nil {
+			dl.State.RecordFailure(res.task.target(), res.err)
+			errors = append(errors, DownloadError{res.task.Name, res.err})
+		}
+	}
+	return errors
+}
+
+func taskHost(task *DownloadTask) string {
+	urls := task.Entry.URLs()
+	if len(urls) == 0 {
+		return ""
+	}
+	parsed, err := url.Parse(urls[0])
+	if err != nil {
+		return ""
+	}
+	return parsed.Host
+}
+
+func (task *DownloadTask) run(cache *Cache, client *http.Client) error {
+	if len(task.Message) != 0 {
+		log.Infof("%s", task.Message)
+	}
+	if task.Entry.Type == TypeInline {
+		err := task.Entry.writeInline(task.Path)
+		if err != nil {
+			return err
+		}
+		return task.finish()
+	}
+	cacheKey := task.Entry.CacheKey()
+	if cache.Get(cacheKey, task.Path) {
+		if task.Entry.VerifyChecksum(task.Path) == nil {
+			log.Infof("Using cached copy of %s", task.Name)
+			return task.finish()
+		}
+		log.Warnf("Cached copy of %s is corrupted, downloading it again", task.Name)
+	}
+	urls := task.Entry.URLs()
+	if len(urls) == 0 {
+		return fmt.Errorf("no download URL")
+	}
+	var err error
+	for i, downloadURL := range urls {
+		if i > 0 {
+			log.Warnf("Failed to download %[1]s: %[2]s, trying mirror %[3]s", task.Name, err, downloadURL)
+		}
+		// Only the last URL retries errors that mean the host is down, as the other URLs can fail over to a mirror.
+		err = task.download(client, downloadURL, i == len(urls)-1)
+		if err == nil {
+			if i > 0 {
+				log.Infof("Downloaded %[1]s from mirror %[2]s", task.Name, downloadURL)
+			}
+			break
+		}
+	}
+	if err != nil {
+		return err
+	}
+	err = cache.Put(cacheKey, task.Path)
+	if err != nil {
+		log.Warnf("Failed to add %[1]s to download cache: %[2]s", task.Name, err)
+	}
+	return task.finish()
+}
+
+func (task *DownloadTask) target() string {
+	if len(task.Target) != 0 {
+		return task.Target
+	}
+	return task.Path
+}
+
+func (task *DownloadTask) record(state *InstallState) error {
+	if len(task.Dir) != 0 {
+		var err error
+		if task.Files != nil {
+			err = state.RecordFiles(task.Dir, task.target(), task.Files, task.Entry, task.Name)
+		} else {
+			err = state.RecordTree(task.Dir, task.target(), task.Entry, task.Name)
+		}
+		if err != nil {
+			return err
+		}
+		state.Set(task.Preserved)
+		return nil
+	}
+	return state.Record(task.Path, task.target(), task.Entry, task.Name)
+}
+
+func (task *DownloadTask) finish() error {
+	if task.Finish != nil {
+		return task.Finish()
+	}
+	return nil
+}
+
+func (task *DownloadTask) download(client *http.Client, downloadURL string, retryAll bool) error {
+	err := downloadFile(client, downloadURL, task.Path, retryAll)
+	if err != nil {
+		return err
+	}
+	err = task.Entry.VerifyChecksum(task.Path)
+	if err != nil {
+		removeErr := os.Remove(task.Path)
+		if removeErr != nil {
+			log.Warnf("Failed to remove corrupted file at %[1]s: %[2]s", task.Path, removeErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// ReportErrors logs the given download errors and returns whether there were any.
+func ReportErrors(errors []error) bool {
+	if len(errors) == 0 {
+		return false
+	}
+	log.Errorf("%d files failed to install:", len(errors))
+	for _, err := range errors {
+		log.Errorf("  %s", err)
+	}
+	return true
+}
+
+const maxDownloadAttempts = 5
+const initialRetryDelay = 1 * time.Second
+const maxRetryDelay = 30 * time.Second
+
+// HTTPStatusError is returned when a download URL responds with a non-2xx status code.
+type HTTPStatusError struct {
+	URL    string
+	Status string
+	Code   int
+}
+
+func (err HTTPStatusError) Error() string {
+	return fmt.Sprintf("%s responded with %s", err.URL, err.Status)
+}
+
+// Temporary returns whether or not the request should be retried.
+func (err HTTPStatusError) Temporary() bool {
+	return err.Code >= 500 || err.Code == http.StatusTooManyRequests || err.Code == http.StatusRequestTimeout
+}
+
+// transferError is returned by downloadPart when the download failed after the server started sending the file.
+type transferError struct {
+	err error
+}
+
+func (err transferError) Error() string {
+	return err.err.Error()
+}
+
+// requestError is returned by downloadPart when the request couldn't be sent or the server didn't respond.
+type requestError struct {
+	err error
+}
+
+func (err requestError) Error() string {
+	return err.err.Error()
+}
+
+// shouldRetry checks whether a failed download should be retried from the same URL. Interrupted transfers are always
+// retried, as they can be resumed, while connection errors and temporary HTTP errors are only retried if retryAll is
+// true, i.e. there's no mirror to fail over to.
+func shouldRetry(err error, retryAll bool) bool {
+	switch typedErr := err.(type) {
+	case transferError:
+		return true
+	case requestError:
+		return retryAll
+	case HTTPStatusError:
+		return retryAll && typedErr.Temporary()
+	default:
+		return false
+	}
+}
+
+// downloadFile downloads the given URL into a .part file next to saveTo and renames it into place once the download
+// is complete. Failed downloads are retried with exponential backoff (see shouldRetry) and resumed using HTTP range
+// requests.
+func downloadFile(client *http.Client, url, saveTo string, retryAll bool) error {
+	partPath := saveTo + ".part"
+	// Parts left over from previous runs may be from a different version of the file, so don't try to resume them.
+	err := os.Remove(partPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	delay := initialRetryDelay
+	for attempt := 1; ; attempt++ {
+		err = downloadPart(client, url, partPath)
+		if err == nil {
+			break
+		} else if !shouldRetry(err, retryAll) {
+			_ = os.Remove(partPath)
+			return err
+		} else if attempt >= maxDownloadAttempts {
+			_ = os.Remove(partPath)
+			return fmt.Errorf("%s (gave up after %d attempts)", err, attempt)
+		}
+		log.Warnf("Failed to download %[1]s: %[2]s, retrying in %[3]s", url, err, delay)
+		time.Sleep(delay)
+		delay *= 2
+		if delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+	}
+	return os.Rename(partPath, saveTo)
+}
+
+// downloadPart downloads the given URL into the given file, continuing from the end of the file if it already exists.
+func downloadPart(client *http.Client, url, partPath string) error {
+	out, err := os.OpenFile(partPath, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	offset, err := out.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return requestError{err}
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && offset > 0:
+		// The server supports ranges, continue where we left off if it sent the range we asked for.
+		var start int64
+		_, err = fmt.Sscanf(resp.Header.Get("Content-Range"), "bytes %d-", &start)
+		if err != nil || start != offset {
+			_ = out.Truncate(0)
+			return transferError{fmt.Errorf("server sent the wrong range (%q) when resuming from byte %d",
+				resp.Header.Get("Content-Range"), offset)}
+		}
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0:
+		// The previous attempt got the whole file, but the connection was closed before we noticed.
+		if resp.Header.Get("Content-Range") == fmt.Sprintf("bytes */%d", offset) {
+			return nil
+		}
+		_ = out.Truncate(0)
+		return transferError{fmt.Errorf("server rejected range request")}
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		// The server sent the whole file, start over.
+		err = out.Truncate(0)
+		if err != nil {
+			return err
+		}
+		_, err = out.Seek(0, io.SeekStart)
+		if err != nil {
+			return err
+		}
+	default:
+		return HTTPStatusError{URL: url, Status: resp.Status, Code: resp.StatusCode}
+	}
+	_, err = io.Copy(out, resp.Body)
+	if err != nil {
+		return transferError{err}
+	}
+	return nil
+}
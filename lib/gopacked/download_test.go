@@ -0,0 +1,227 @@
+// goPacked - A simple text-based Minecraft modpack manager.
+// Copyright (C) 2019 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package gopacked
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// requestLog records the requests made to test servers in the order they arrived.
+type requestLog struct {
+	lock    sync.Mutex
+	order   []string
+	active  map[string]int
+	maxSeen map[string]int
+}
+
+func (rl *requestLog) handler(name string, delay time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rl.lock.Lock()
+		rl.order = append(rl.order, name)
+		rl.active[name]++
+		if rl.active[name] > rl.maxSeen[name] {
+			rl.maxSeen[name] = rl.active[name]
+		}
+		rl.lock.Unlock()
+		time.Sleep(delay)
+		_, _ = w.Write([]byte(r.URL.Path))
+		rl.lock.Lock()
+		rl.active[name]--
+		rl.lock.Unlock()
+	})
+}
+
+func TestDownloaderPerHostDispatch(t *testing.T) {
+	rl := &requestLog{active: make(map[string]int), maxSeen: make(map[string]int)}
+	busy := httptest.NewServer(rl.handler("busy", 50*time.Millisecond))
+	defer busy.Close()
+	other := httptest.NewServer(rl.handler("other", 0))
+	defer other.Close()
+
+	dir, err := ioutil.TempDir("", "gopacked-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	dl := NewDownloader(4, 2)
+	// The tasks of the other host are planned last, so they'd wait behind the busy host if workers blocked on it.
+	for i := 0; i < 8; i++ {
+		dl.Add(&DownloadTask{
+			Name:  fmt.Sprintf("busy%d", i),
+			Entry: FileEntry{Type: TypeFile, URL: fmt.Sprintf("%s/busy%d", busy.URL, i)},
+			Path:  filepath.Join(dir, fmt.Sprintf("busy%d", i)),
+		})
+	}
+	for i := 0; i < 2; i++ {
+		dl.Add(&DownloadTask{
+			Name:  fmt.Sprintf("other%d", i),
+			Entry: FileEntry{Type: TypeFile, URL: fmt.Sprintf("%s/other%d", other.URL, i)},
+			Path:  filepath.Join(dir, fmt.Sprintf("other%d", i)),
+		})
+	}
+	if errors := dl.Run(); len(errors) != 0 {
+		t.Fatalf("downloads failed: %v", errors)
+	}
+
+	if rl.maxSeen["busy"] > 2 {
+		t.Errorf("expected at most 2 concurrent requests to one host, got %d", rl.maxSeen["busy"])
+	}
+	if len(rl.order) != 10 {
+		t.Fatalf("expected 10 requests, got %d", len(rl.order))
+	}
+	otherStarted := 0
+	for _, name := range rl.order[:4] {
+		if name == "other" {
+			otherStarted++
+		}
+	}
+	if otherStarted != 2 {
+		t.Errorf("expected the other host to be downloaded from while the busy host was at its limit, order: %v", rl.order)
+	}
+	for i := 0; i < 8; i++ {
+		data, err := ioutil.ReadFile(filepath.Join(dir, fmt.Sprintf("busy%d", i)))
+		if err != nil || string(data) != fmt.Sprintf("/busy%d", i) {
+			t.Errorf("busy%d wasn't downloaded correctly: %q %v", i, data, err)
+		}
+	}
+}
+
+func TestMirrorFailover(t *testing.T) {
+	dead := httptest.NewServer(http.NotFoundHandler())
+	deadURL := dead.URL
+	dead.Close()
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down for maintenance", http.StatusServiceUnavailable)
+	}))
+	defer broken.Close()
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("mod"))
+	}))
+	defer mirror.Close()
+
+	dir, err := ioutil.TempDir("", "gopacked-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	task := &DownloadTask{
+		Name:  "mod",
+		Entry: FileEntry{Type: TypeFile, URL: deadURL + "/mod.jar", Mirrors: []string{broken.URL + "/mod.jar", mirror.URL + "/mod.jar"}},
+		Path:  filepath.Join(dir, "mod.jar"),
+	}
+	start := time.Now()
+	err = task.run(nil, HTTPClient)
+	if err != nil {
+		t.Fatalf("download failed: %s", err)
+	} else if elapsed := time.Since(start); elapsed >= initialRetryDelay {
+		t.Errorf("failing over to the mirror took %s, expected no retries", elapsed)
+	}
+	if data, _ := ioutil.ReadFile(task.Path); string(data) != "mod" {
+		t.Errorf("expected the file from the mirror, got %q", data)
+	}
+}
+
+func TestShouldRetry(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		retryAll bool
+		expected bool
+	}{
+		{"interrupted transfer", transferError{fmt.Errorf("unexpected EOF")}, false, true},
+		{"connection error with mirrors", requestError{fmt.Errorf("connection refused")}, false, false},
+		{"connection error without mirrors", requestError{fmt.Errorf("connection refused")}, true, true},
+		{"server error with mirrors", HTTPStatusError{Code: 503}, false, false},
+		{"server error without mirrors", HTTPStatusError{Code: 503}, true, true},
+		{"not found", HTTPStatusError{Code: 404}, true, false},
+		{"local error", fmt.Errorf("disk full"), true, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if retry := shouldRetry(test.err, test.retryAll); retry != test.expected {
+				t.Errorf("expected %t, got %t", test.expected, retry)
+			}
+		})
+	}
+}
+
+func TestDownloadPartResume(t *testing.T) {
+	content := []byte("0123456789abcdefghij")
+	tests := []struct {
+		name         string
+		contentRange func(offset int) string
+		expected     []byte
+		fails        bool
+	}{
+		{"matching range", func(offset int) string {
+			return fmt.Sprintf("bytes %d-%d/%d", offset, len(content)-1, len(content))
+		}, content, false},
+		{"wrong start", func(offset int) string {
+			return fmt.Sprintf("bytes 0-%d/%d", len(content)-1, len(content))
+		}, []byte{}, true},
+		{"missing header", func(offset int) string {
+			return ""
+		}, []byte{}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var offset int
+				if _, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-", &offset); err != nil {
+					t.Errorf("expected a range request, got %q", r.Header.Get("Range"))
+				}
+				if contentRange := test.contentRange(offset); len(contentRange) != 0 {
+					w.Header().Set("Content-Range", contentRange)
+				}
+				w.WriteHeader(http.StatusPartialContent)
+				_, _ = w.Write(content[offset:])
+			}))
+			defer server.Close()
+
+			dir, err := ioutil.TempDir("", "gopacked-test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			partPath := filepath.Join(dir, "file.part")
+			if err = ioutil.WriteFile(partPath, content[:8], 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			err = downloadPart(HTTPClient, server.URL, partPath)
+			if _, ok := err.(transferError); test.fails && !ok {
+				t.Errorf("expected a transfer error, got %v", err)
+			} else if !test.fails && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			if data, _ := ioutil.ReadFile(partPath); !bytes.Equal(data, test.expected) {
+				t.Errorf("expected part file to contain %q, got %q", test.expected, data)
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+// goPacked - A simple text-based Minecraft modpack manager.
+// Copyright (C) 2019 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package gopacked
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// ParentInfo identifies the definition a goPack definition was extended from.
+type ParentInfo struct {
+	Name    string  `json:"name"`
+	URL     string  `json:"url"`
+	Version Version `json:"version"`
+}
+
+// inheritedFields are the fields of a goPack definition that are inherited from the definition it extends.
+var inheritedFields = []string{
+	"files", "mcl-version", "profile-settings", "forge-version", "minecraft-version", "variables",
+}
+
+// Extend merges the given child definition on top of the given parent definition and returns the resolved definition.
+// The inherited fields (files, mcl-version, profile-settings, forge-version, minecraft-version and variables) are
+// deep-merged: objects are merged key by key, other values in the child replace the values in the parent and null
+// values in the child remove the key. File entries other than directories aren't merged, but replaced as a whole.
+// All other fields come from the child. Both definitions are migrated to the current format before merging.
+func Extend(parent, child []byte) ([]byte, error) {
+	parentData, err := decodeDefinition(parent)
+	if err == nil {
+		err = migrateDefinition(parentData)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse parent definition: %s", err)
+	}
+	childData, err := decodeDefinition(child)
+	if err == nil {
+		err = migrateDefinition(childData)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse definition: %s", err)
+	}
+	for _, field := range inheritedFields {
+		childValue, ok := childData[field]
+		if !ok {
+			childValue = make(map[string]interface{})
+		} else if childValue == nil {
+			delete(childData, field)
+			continue
+		}
+		if parentValue, ok := parentData[field]; !ok {
+			continue
+		} else if field == "files" || field == "mcl-version" {
+			childData[field] = mergeEntry(parentValue, childValue)
+		} else {
+			childData[field] = deepMerge(parentValue, childValue)
+		}
+	}
+	return json.Marshal(childData)
+}
+
+func deepMerge(base, override interface{}) interface{} {
+	overrideMap, ok := override.(map[string]interface{})
+	if !ok {
+		return override
+	}
+	baseMap, ok := base.(map[string]interface{})
+	if !ok && len(overrideMap) == 0 {
+		// The child didn't set the field at all, so just use the value from the parent.
+		return base
+	}
+	merged := make(map[string]interface{}, len(baseMap)+len(overrideMap))
+	for key, value := range baseMap {
+		merged[key] = value
+	}
+	for key, value := range overrideMap {
+		if value == nil {
+			delete(merged, key)
+		} else {
+			merged[key] = deepMerge(merged[key], value)
+		}
+	}
+	return merged
+}
+
+// mergeEntry merges a raw file entry of the child definition on top of the inherited one. Directories are merged
+// field by field and their children by name, but other entries replace the inherited entry as a whole, as e.g. the
+// checksums of an inherited file don't apply to the file at a different URL.
+func mergeEntry(base, override interface{}) interface{} {
+	overrideMap, ok := override.(map[string]interface{})
+	if !ok {
+		return override
+	} else if len(overrideMap) == 0 {
+		// The child didn't change the entry at all, so just use the value from the parent.
+		return base
+	}
+	baseMap, ok := base.(map[string]interface{})
+	if !ok || baseMap["type"] != string(TypeDirectory) {
+		return override
+	} else if overrideType, ok := overrideMap["type"]; ok && overrideType != string(TypeDirectory) {
+		return override
+	}
+	merged := make(map[string]interface{}, len(baseMap)+len(overrideMap))
+	for key, value := range baseMap {
+		merged[key] = value
+	}
+	for key, value := range overrideMap {
+		if value == nil {
+			delete(merged, key)
+		} else if key == "children" {
+			merged[key] = mergeChildren(merged[key], value)
+		} else {
+			merged[key] = value
+		}
+	}
+	return merged
+}
+
+// mergeChildren merges the raw children of a directory entry in the child definition on top of the inherited ones.
+func mergeChildren(base, override interface{}) interface{} {
+	overrideMap, ok := override.(map[string]interface{})
+	if !ok {
+		return override
+	}
+	baseMap, _ := base.(map[string]interface{})
+	merged := make(map[string]interface{}, len(baseMap)+len(overrideMap))
+	for name, entry := range baseMap {
+		merged[name] = entry
+	}
+	for name, entry := range overrideMap {
+		if entry == nil {
+			delete(merged, name)
+		} else {
+			merged[name] = mergeEntry(merged[name], entry)
+		}
+	}
+	return merged
+}
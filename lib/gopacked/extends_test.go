@@ -0,0 +1,149 @@
+// goPacked - A simple text-based Minecraft modpack manager.
+// Copyright (C) 2019 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package gopacked
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func decodeJSON(t *testing.T, data string) interface{} {
+	var value interface{}
+	if err := json.Unmarshal([]byte(data), &value); err != nil {
+		t.Fatalf("invalid test JSON %s: %s", data, err)
+	}
+	return value
+}
+
+func TestDeepMerge(t *testing.T) {
+	tests := []struct {
+		name     string
+		base     string
+		override string
+		expected string
+	}{
+		{"empty override", `{"a": 1}`, `{}`, `{"a": 1}`},
+		{"new key", `{"a": 1}`, `{"b": 2}`, `{"a": 1, "b": 2}`},
+		{"replace value", `{"a": 1}`, `{"a": "x"}`, `{"a": "x"}`},
+		{"remove key", `{"a": 1, "b": 2}`, `{"a": null}`, `{"b": 2}`},
+		{"nested", `{"a": {"b": 1, "c": 2}}`, `{"a": {"c": 3}}`, `{"a": {"b": 1, "c": 3}}`},
+		{"replace object with value", `{"a": {"b": 1}}`, `{"a": [1]}`, `{"a": [1]}`},
+		{"no base", `null`, `{"a": 1}`, `{"a": 1}`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			merged := deepMerge(decodeJSON(t, test.base), decodeJSON(t, test.override))
+			if expected := decodeJSON(t, test.expected); !reflect.DeepEqual(merged, expected) {
+				t.Errorf("expected %v, got %v", expected, merged)
+			}
+		})
+	}
+}
+
+func TestMergeEntry(t *testing.T) {
+	base := `{"type": "directory", "children": {
+		"mods": {"type": "directory", "children": {
+			"A": {"type": "file", "version": "1", "url": "a1.jar", "sha1": "aaaa"},
+			"B": {"type": "zip-archive", "version": "1", "url": "b.zip", "strip-components": 1}
+		}},
+		"options.txt": {"type": "inline", "content": "x"}
+	}}`
+	tests := []struct {
+		name     string
+		override string
+		expected string
+	}{
+		{"unchanged", `{}`, base},
+		{"replace file as a whole", `{"children": {"mods": {"children": {"A": {"type": "file", "version": "2", "url": "a2.jar"}}}}}`,
+			`{"type": "directory", "children": {
+				"mods": {"type": "directory", "children": {
+					"A": {"type": "file", "version": "2", "url": "a2.jar"},
+					"B": {"type": "zip-archive", "version": "1", "url": "b.zip", "strip-components": 1}
+				}},
+				"options.txt": {"type": "inline", "content": "x"}
+			}}`},
+		{"partial archive override", `{"children": {"mods": {"children": {"B": {"url": "b2.zip"}}}}}`,
+			`{"type": "directory", "children": {
+				"mods": {"type": "directory", "children": {
+					"A": {"type": "file", "version": "1", "url": "a1.jar", "sha1": "aaaa"},
+					"B": {"url": "b2.zip"}
+				}},
+				"options.txt": {"type": "inline", "content": "x"}
+			}}`},
+		{"remove and add children", `{"children": {"mods": {"children": {"A": null, "C": {"type": "file", "url": "c.jar"}}}, "options.txt": null}}`,
+			`{"type": "directory", "children": {
+				"mods": {"type": "directory", "children": {
+					"B": {"type": "zip-archive", "version": "1", "url": "b.zip", "strip-components": 1},
+					"C": {"type": "file", "url": "c.jar"}
+				}}
+			}}`},
+		{"directory fields", `{"filename": "game", "children": {"mods": {"side": "client"}}}`,
+			`{"type": "directory", "filename": "game", "children": {
+				"mods": {"type": "directory", "side": "client", "children": {
+					"A": {"type": "file", "version": "1", "url": "a1.jar", "sha1": "aaaa"},
+					"B": {"type": "zip-archive", "version": "1", "url": "b.zip", "strip-components": 1}
+				}},
+				"options.txt": {"type": "inline", "content": "x"}
+			}}`},
+		{"replace directory with file", `{"children": {"mods": {"type": "file", "url": "mods.jar"}}}`,
+			`{"type": "directory", "children": {
+				"mods": {"type": "file", "url": "mods.jar"},
+				"options.txt": {"type": "inline", "content": "x"}
+			}}`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			merged := mergeEntry(decodeJSON(t, base), decodeJSON(t, test.override))
+			if expected := decodeJSON(t, test.expected); !reflect.DeepEqual(merged, expected) {
+				t.Errorf("expected %v, got %v", expected, merged)
+			}
+		})
+	}
+}
+
+func TestExtend(t *testing.T) {
+	parent := `{
+		"name": "Base", "simplename": "base", "update-url": "http://example.com/base.json", "author": "a",
+		"version": "1.0", "forge-version": "1.12.2-14.23.5.2847",
+		"profile-settings": {"javaArgs": "-Xmx2G", "icon": "x"},
+		"files": {"type": "directory", "children": {"A": {"type": "file", "version": "1", "url": "a.jar", "sha1": "aaaa"}}}
+	}`
+	child := `{
+		"name": "Child", "simplename": "child", "update-url": "http://example.com/child.json", "author": "b",
+		"version": "2.0", "extends": "http://example.com/base.json",
+		"profile-settings": {"icon": null},
+		"files": {"children": {"A": {"type": "file", "version": "2", "url": "a2.jar"}}}
+	}`
+	data, err := Extend([]byte(parent), []byte(child))
+	if err != nil {
+		t.Fatalf("failed to extend: %s", err)
+	}
+	var gp GoPack
+	if err = json.Unmarshal(data, &gp); err != nil {
+		t.Fatalf("failed to parse extended definition: %s", err)
+	}
+	if gp.Name != "Child" || gp.Version != "2.0" || gp.ForgeVer != "1.12.2-14.23.5.2847" {
+		t.Errorf("unexpected base fields: %s %s %s", gp.Name, gp.Version, gp.ForgeVer)
+	}
+	if !reflect.DeepEqual(gp.ProfileArgs, map[string]interface{}{"javaArgs": "-Xmx2G"}) {
+		t.Errorf("unexpected profile settings: %v", gp.ProfileArgs)
+	}
+	if a := gp.Files.Children["A"]; a.URL != "a2.jar" || a.SHA1 != "" {
+		t.Errorf("inherited file entry wasn't replaced: %+v", a)
+	}
+}
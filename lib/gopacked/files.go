@@ -17,72 +17,83 @@
 package gopacked
 
 import (
-	"io"
-	"maunium.net/go/gopacked/lib/archive"
-	"net/http"
+	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
 
+	"maunium.net/go/gopacked/lib/archive"
 	"maunium.net/go/gopacked/lib/log"
 )
 
-// Install installs the file entry to the given path.
-func (fe FileEntry) Install(path, name string, side Side) {
-	if !fe.checkSide(side) {
+// Install installs the file entry to the given path. Directories are created immediately,
+// but the files are only added to the download plan of the given Downloader.
+func (fe FileEntry) Install(dl *Downloader, path, name string, side Side) {
+	fe.install(dl, nil, path, name, side)
+}
+
+// install installs the file entry to the given path. If a transaction is given, the files are downloaded into the
+// staging directory of the transaction and directories are only created when the transaction is committed.
+func (fe FileEntry) install(dl *Downloader, tx *Transaction, path, name string, side Side) {
+	if !fe.applies(side) {
 		return
 	}
 	if fe.Type == TypeDirectory {
-		log.Infof("Creating directory %s", name)
-		err := os.MkdirAll(path, 0755)
-		if err != nil {
-			log.Warnf("Failed to create %[1]s: %[2]s", name, err)
+		if tx == nil {
+			log.Infof("Creating directory %s", name)
+			err := os.MkdirAll(path, 0755)
+			if err != nil {
+				log.Warnf("Failed to create %[1]s: %[2]s", name, err)
+			}
+		} else {
+			tx.Mkdir(path)
 		}
 		for key, value := range fe.Children {
-			value.Install(value.path(path, key), key, side)
+			value.install(dl, tx, value.path(path, key), key, side)
 		}
 	} else if fe.Type == TypeFile {
-		log.Infof("Downloading %[1]s v%[2]s", name, fe.Version)
-		err := downloadFile(fe.URL, path)
-		if err != nil {
-			log.Errorf("Failed to download %[1]s: %[2]s", name, err)
-		}
-	} else if fe.Type == TypeZipArchive {
-		log.Infof("Downloading and unzipping %[1]s v%[2]s", name, fe.Version)
-		err := os.MkdirAll(path, 0755)
-		if err != nil {
-			log.Warnf("Failed to create directory for %[1]s: %[2]s", name, err)
-			return
-		}
-		archivePath := filepath.Join(path, "temp-archive.zip")
-		err = downloadFile(fe.URL, archivePath)
-		if err != nil {
-			log.Errorf("Failed to download %[1]s: %[2]s", name, err)
-			return
-		}
-		err = archive.Unzip(archivePath, path)
-		if err != nil {
-			log.Errorf("Failed to Unzip %[1]s: %[2]s", name, err)
-		}
-		err = os.Remove(archivePath)
-		if err != nil {
-			log.Warnf("Failed to remove temp archive file: %[1]s", err)
+		fe.planDownload(dl, tx, path, name, fmt.Sprintf("Downloading %[1]s v%[2]s", name, fe.Version))
+	} else if fe.Type == TypeInline {
+		fe.planDownload(dl, tx, path, name, fmt.Sprintf("Writing %[1]s", name))
+	} else if fe.Type == TypePatch {
+		dl.addPatch(fe, path, name, false)
+	} else if fe.Type.IsArchive() {
+		message := fmt.Sprintf("Downloading and extracting %[1]s v%[2]s", name, fe.Version)
+		if fe.merges() {
+			fe.planMerge(dl, tx, path, name, message)
+		} else {
+			fe.planArchive(dl, tx, path, name, message)
 		}
 	}
 }
 
-// Remove removes the given FileEntry from the given path.
-func (fe FileEntry) Remove(path, name string, side Side) {
-	if !fe.checkSide(side) {
+// Remove removes the given FileEntry from the given path. The install state is used to find the files of merge-mode
+// archives and can be nil.
+func (fe FileEntry) Remove(state *InstallState, path, name string, side Side) {
+	if !fe.applies(side) {
 		return
 	}
-	if fe.Type == TypeDirectory || fe.Type == TypeZipArchive {
+	if fe.Type == TypePatch {
+		log.Infof("Reverting %[1]s in %[2]s...", name, path)
+		err := fe.revertPatch(state, path, path, name)
+		if err != nil {
+			log.Errorf("Failed to revert %[1]s: %[2]s", name, err)
+		}
+	} else if fe.merges() {
+		log.Infof("Removing files of %[1]s from %[2]s...", name, path)
+		for _, key := range state.Owned(path, name) {
+			err := os.Remove(state.Path(key))
+			if err != nil && !os.IsNotExist(err) {
+				log.Errorf("Failed to remove %[1]s: %[2]s", state.Path(key), err)
+			}
+		}
+	} else if fe.Type == TypeDirectory || fe.Type.IsArchive() {
 		log.Infof("Removing %[1]s...", path)
 		err := os.RemoveAll(path)
 		if err != nil {
 			log.Errorf("Failed to remove %[1]s: %[2]s", path, err)
 		}
-	} else if fe.Type == TypeFile {
+	} else if fe.Type == TypeFile || fe.Type == TypeInline {
 		log.Infof("Removing %[1]s v%[2]s...", name, fe.Version)
 		err := os.Remove(path)
 		if err != nil {
@@ -91,29 +102,45 @@ func (fe FileEntry) Remove(path, name string, side Side) {
 	}
 }
 
-// Update updates this FileEntry to the given new version.
-func (fe FileEntry) Update(new FileEntry, path, newpath, name string, side Side) {
-	if !fe.checkSide(side) {
+// Update updates this FileEntry to the given new version. The new files are downloaded into the staging directory of
+// the given transaction and the old files are only replaced or removed when the transaction is committed.
+func (fe FileEntry) Update(dl *Downloader, tx *Transaction, new FileEntry, path, newpath, name string, side Side) {
+	if !new.applies(side) {
+		if fe.applies(side) {
+			log.Infof("Removing %[1]s, as it no longer applies to this system", name)
+			fe.planRemove(dl, tx, path, name)
+		}
+		return
+	} else if !fe.applies(side) {
+		new.install(dl, tx, newpath, name, side)
 		return
 	}
-	if fe.Type == TypeDirectory {
-		if _, err := os.Stat(path); os.IsNotExist(err) {
-			log.Infof("Creating directory %s", name)
-			err = os.MkdirAll(path, 0755)
-			if err != nil {
-				log.Warnf("Failed to create directory %s", name)
-			}
+	if fe.Type != new.Type {
+		log.Infof("Replacing %[1]s %[2]s with %[3]s", fe.Type, name, new.Type)
+		fe.planRemove(dl, tx, path, name)
+		new.install(dl, tx, newpath, name, side)
+	} else if fe.merges() != new.merges() {
+		log.Infof("Reinstalling %[1]s v%[2]s in %[3]s mode", name, new.Version, new.extractMode())
+		fe.planRemove(dl, tx, path, name)
+		new.install(dl, tx, newpath, name, side)
+	} else if fe.Type == TypePatch {
+		// Patches are always reapplied, as the file they patch may have been replaced.
+		if path != newpath {
+			fe.planRemove(dl, tx, path, name)
 		}
+		dl.addPatch(new, newpath, name, false)
+	} else if fe.Type == TypeDirectory {
 		// Loop through the old file list. This loop updates outdated files and removes files that are no longer
 		// in the updated modpack definition.
 		for key, value := range fe.Children {
 			newVal, ok := new.Children[key]
 			if ok {
 				// File already exists, call Update
-				value.Update(newVal, value.path(path, key), newVal.path(path, key), key, side)
-			} else {
-				// File no longer exists, call Remove
-				value.Remove(value.path(path, key), key, side)
+				value.Update(dl, tx, newVal, value.path(path, key), newVal.path(newpath, key), key, side)
+			} else if value.applies(side) {
+				// File no longer exists, remove it
+				log.Infof("Removing %[1]s", key)
+				value.planRemove(dl, tx, value.path(path, key), key)
 			}
 		}
 
@@ -121,62 +148,86 @@ func (fe FileEntry) Update(new FileEntry, path, newpath, name string, side Side)
 		for key, value := range new.Children {
 			_, ok := fe.Children[key]
 			if !ok {
-				// File didn't exist before, call Install
-				value.Install(value.path(path, key), key, side)
+				// File didn't exist before, call install
+				value.install(dl, tx, value.path(newpath, key), key, side)
 			}
 		}
-	} else if fe.Type == TypeFile || fe.Type == TypeZipArchive {
+	} else if fe.Type == TypeFile || fe.Type == TypeInline || fe.Type.IsArchive() {
 		// Compare the versions of the new and old file.
 		compare := new.Version.Compare(fe.Version)
 
 		// If the version number of the new file is different from the current one, upgrade (or downgrade) it.
+		var message string
 		if compare == 1 {
-			log.Infof("Updating %[1]s from v%[2]s to v%[3]s", name, fe.Version, new.Version)
+			message = fmt.Sprintf("Updating %[1]s from v%[2]s to v%[3]s", name, fe.Version, new.Version)
 		} else if compare == -1 {
-			log.Infof("Downgrading %[1]s from v%[2]s to v%[3]s", name, fe.Version, new.Version)
+			message = fmt.Sprintf("Downgrading %[1]s from v%[2]s to v%[3]s", name, fe.Version, new.Version)
+		} else if path != newpath {
+			message = fmt.Sprintf("Reinstalling %[1]s v%[2]s to a new location", name, new.Version)
+		} else if !fe.installed(dl.State, path, name) {
+			message = fmt.Sprintf("Reinstalling %[1]s v%[2]s, as it wasn't installed properly", name, new.Version)
+		} else if new.Type == TypeInline && new.contentChanged(dl.State, path) {
+			message = fmt.Sprintf("Updating %[1]s, as its content has changed", name)
 		} else {
 			return
 		}
 
-		if fe.Type == TypeFile {
-			err := os.Remove(path)
-			if err != nil {
-				log.Warnf("Failed to remove file at %[1]s: %[2]s", path, err)
-			}
-			err = downloadFile(new.URL, newpath)
-			if err != nil {
-				log.Errorf("Failed to download %[1]s: %[2]s", name, err)
-			}
-		} else if fe.Type == TypeZipArchive {
-			err := os.RemoveAll(path)
-			if err != nil {
-				log.Warnf("Failed to remove directory at %[1]s: %[2]s", path, err)
-			}
-			err = os.MkdirAll(newpath, 0755)
-			if err != nil {
-				log.Warnf("Failed to create directory for %[1]s: %[2]s", name, err)
-				return
-			}
-			archivePath := filepath.Join(newpath, "temp-archive.zip")
-			err = downloadFile(fe.URL, archivePath)
-			if err != nil {
-				log.Errorf("Failed to download %[1]s: %[2]s", name, err)
+		if path != newpath {
+			fe.planRemove(dl, tx, path, name)
+		}
+		if fe.Type == TypeFile || fe.Type == TypeInline {
+			if path == newpath && !new.preserveFile(dl.State, tx, path, name) {
 				return
 			}
-			err = archive.Unzip(archivePath, newpath)
-			if err != nil {
-				log.Errorf("Failed to Unzip %[1]s: %[2]s", name, err)
-			}
-			err = os.Remove(archivePath)
-			if err != nil {
-				log.Warnf("Failed to remove temp archive file: %[1]s", err)
+			new.planDownload(dl, tx, newpath, name, message)
+		} else if new.merges() {
+			new.planMerge(dl, tx, newpath, name, message)
+		} else if fe.Type.IsArchive() {
+			// The old records are needed to find the modified files, so take a copy before planArchive forgets them.
+			oldState := dl.State.Copy()
+			task := new.planArchive(dl, tx, newpath, name, message)
+			if task != nil && path == newpath {
+				extract := task.Finish
+				task.Finish = func() (err error) {
+					err = extract()
+					if err == nil {
+						task.Preserved, err = new.preserveArchive(oldState, path, task.Dir, name)
+					}
+					return
+				}
 			}
 		}
 	}
 }
 
+// planRemove schedules the files of this file entry at the given path to be removed when the transaction is committed.
+// Patch entries are reverted when the planned patches are applied instead.
+func (fe FileEntry) planRemove(dl *Downloader, tx *Transaction, path, name string) {
+	if fe.Type == TypePatch {
+		// Patched files aren't owned by the patch, so only the patched keys are reverted.
+		dl.addPatch(fe, path, name, true)
+		return
+	} else if fe.merges() {
+		for _, key := range dl.State.Owned(path, name) {
+			tx.Remove(dl.State.Path(key))
+			dl.State.Forget(dl.State.Path(key))
+		}
+		return
+	}
+	tx.Remove(path)
+	dl.State.Forget(path)
+}
+
+// installed checks whether the files of this file entry have been installed to the given path.
+func (fe FileEntry) installed(state *InstallState, path, name string) bool {
+	if fe.merges() && state != nil && !state.Legacy {
+		return len(state.Owned(path, name)) > 0
+	}
+	return state.Has(path)
+}
+
 func (fe FileEntry) path(path, name string) string {
-	if fe.Type == TypeDirectory || fe.Type == TypeZipArchive {
+	if fe.Type == TypeDirectory || fe.Type.IsArchive() {
 		if len(fe.FileName) != 0 {
 			if fe.FileName != "//" {
 				path = filepath.Join(path, fe.FileName)
@@ -187,32 +238,131 @@ func (fe FileEntry) path(path, name string) string {
 	} else if fe.Type == TypeFile {
 		if len(fe.FileName) != 0 {
 			path = filepath.Join(path, fe.FileName)
-		} else {
-			split := strings.Split(fe.URL, "/")
+		} else if urls := fe.URLs(); len(urls) != 0 {
+			split := strings.Split(urls[0], "/")
 			path = filepath.Join(path, split[len(split)-1])
 		}
+	} else if fe.Type == TypeInline || fe.Type == TypePatch {
+		if len(fe.FileName) != 0 {
+			path = filepath.Join(path, fe.FileName)
+		} else if len(name) != 0 {
+			path = filepath.Join(path, name)
+		}
 	}
 	return path
 }
 
-func (fe FileEntry) checkSide(side Side) bool {
-	return len(fe.Side) == 0 || side == fe.Side || side == SideBoth
+// URLs returns the URL and mirrors of this file entry in the order they should be tried.
+func (fe FileEntry) URLs() []string {
+	urls := make([]string, 0, len(fe.Mirrors)+1)
+	if len(fe.URL) != 0 {
+		urls = append(urls, fe.URL)
+	}
+	for _, mirror := range fe.Mirrors {
+		if len(mirror) != 0 && mirror != fe.URL {
+			urls = append(urls, mirror)
+		}
+	}
+	return urls
 }
 
-func downloadFile(url, saveTo string) error {
-	out, err := os.Create(saveTo)
-	defer out.Close()
-	if err != nil {
-		return err
+// applies checks whether this file entry should be installed on the given side and the current system.
+func (fe FileEntry) applies(side Side) bool {
+	return (len(fe.Side) == 0 || side == fe.Side || side == SideBoth) && fe.Conditions.Match()
+}
+
+// planDownload adds this file entry to the download plan. If a transaction is given, the file is downloaded into the
+// staging directory and moved to the given path when the transaction is committed.
+func (fe FileEntry) planDownload(dl *Downloader, tx *Transaction, path, name, message string) {
+	downloadPath := path
+	if tx != nil {
+		downloadPath = tx.Stage(path)
+		tx.Replace(downloadPath, path)
 	}
-	resp, err := http.Get(url)
-	defer resp.Body.Close()
-	if err != nil {
-		return err
+	dl.State.Forget(path)
+	dl.Add(&DownloadTask{
+		Name:    name,
+		Message: message,
+		Entry:   fe,
+		Path:    downloadPath,
+		Target:  path,
+	})
+}
+
+// planArchive adds this file entry to the download plan so that it's downloaded into a temporary file and
+// extracted to the given path. Like planDownload, the archive is extracted into the staging directory if a
+// transaction is given.
+func (fe FileEntry) planArchive(dl *Downloader, tx *Transaction, target, name, message string) *DownloadTask {
+	path := target
+	if tx != nil {
+		path = tx.Stage(target)
+		tx.Replace(path, target)
 	}
-	_, err = io.Copy(out, resp.Body)
+	dl.State.Forget(target)
+	err := os.MkdirAll(path, 0755)
 	if err != nil {
-		return err
+		log.Warnf("Failed to create directory for %[1]s: %[2]s", name, err)
+		return nil
+	}
+	// The archive is downloaded next to the target directory rather than inside it,
+	// so that it doesn't end up being extracted or recorded as part of the directory.
+	archivePath := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".gopacked-archive")
+	task := &DownloadTask{
+		Name:    name,
+		Message: message,
+		Entry:   fe,
+		Path:    archivePath,
+		Target:  target,
+		Dir:     path,
+		Finish: func() error {
+			defer func() {
+				err := os.Remove(archivePath)
+				if err != nil {
+					log.Warnf("Failed to remove temp archive file: %[1]s", err)
+				}
+			}()
+			err := fe.extract(archivePath, path)
+			if archive.IsUnsafePath(err) {
+				return fmt.Errorf("refusing to extract: %s", err)
+			} else if err != nil {
+				return fmt.Errorf("failed to extract: %s", err)
+			}
+			return nil
+		},
+	}
+	dl.Add(task)
+	return task
+}
+
+// filter returns the archive filter specified by this file entry, or nil if the whole archive should be extracted.
+func (fe FileEntry) filter() *archive.Filter {
+	if fe.StripComponents <= 0 && len(fe.ArchivePath) == 0 && len(fe.Include) == 0 && len(fe.Exclude) == 0 {
+		return nil
+	}
+	return &archive.Filter{
+		StripComponents: fe.StripComponents,
+		SubPath:         fe.ArchivePath,
+		Include:         fe.Include,
+		Exclude:         fe.Exclude,
+	}
+}
+
+// extract extracts the archive at the given path to the target directory based on the type of this file entry.
+func (fe FileEntry) extract(archivePath, target string) error {
+	switch fe.Type {
+	case TypeZipArchive:
+		return archive.Unzip(archivePath, target, fe.filter())
+	case TypeTarGzArchive, TypeTarBz2Archive:
+		file, err := os.Open(archivePath)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		if fe.Type == TypeTarGzArchive {
+			return archive.Untargz(file, target, fe.filter())
+		}
+		return archive.Untarbz2(file, target, fe.filter())
+	default:
+		return fmt.Errorf("unknown archive type %s", fe.Type)
 	}
-	return nil
 }
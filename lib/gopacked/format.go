@@ -0,0 +1,160 @@
+// goPacked - A simple text-based Minecraft modpack manager.
+// Copyright (C) 2019 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package gopacked
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// CurrentFormatVersion is the version of the goPack definition format used by this version of goPacked.
+// Definitions in older formats are migrated when they're loaded and definitions are always saved in this format.
+const CurrentFormatVersion = 2
+
+// LegacyFormatVersion is the format version of definitions that don't have the format-version field.
+const LegacyFormatVersion = 1
+
+// migration upgrades a raw goPack definition to the next format version.
+type migration func(definition map[string]interface{}) error
+
+// migrations contains the migrations between format versions. The migration at index i upgrades definitions from
+// format version i+1 to i+2.
+var migrations = []migration{
+	migrateGoPackedVersionRange,
+}
+
+// FormatError is returned when a goPack definition can't be migrated to the current format.
+type FormatError struct {
+	FormatVersion int
+	Err           error
+}
+
+func (err FormatError) Error() string {
+	if err.FormatVersion > CurrentFormatVersion {
+		return fmt.Sprintf("definition uses format version %d, but this version of goPacked only supports "+
+			"format versions up to %d", err.FormatVersion, CurrentFormatVersion)
+	}
+	return fmt.Sprintf("failed to migrate definition from format version %d: %s", err.FormatVersion, err.Err)
+}
+
+// UnmarshalJSON unmarshals a goPack definition, migrating it to the current format first if necessary.
+func (gp *GoPack) UnmarshalJSON(data []byte) error {
+	definition, err := decodeDefinition(data)
+	if err != nil {
+		return err
+	}
+	err = migrateDefinition(definition)
+	if err != nil {
+		return err
+	}
+	data, err = json.Marshal(definition)
+	if err != nil {
+		return err
+	}
+	// The plain type doesn't have the UnmarshalJSON method, which prevents infinite recursion.
+	type plainGoPack GoPack
+	return json.Unmarshal(data, (*plainGoPack)(gp))
+}
+
+// decodeDefinition decodes a raw goPack definition, keeping numbers as-is so that re-encoding doesn't change them.
+func decodeDefinition(data []byte) (definition map[string]interface{}, err error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	err = decoder.Decode(&definition)
+	if err == nil && definition == nil {
+		err = fmt.Errorf("definition is not a JSON object")
+	}
+	return
+}
+
+// formatVersion returns the format version of the given raw goPack definition.
+func formatVersion(definition map[string]interface{}) (int, error) {
+	rawVersion, ok := definition["format-version"]
+	if !ok || rawVersion == nil {
+		return LegacyFormatVersion, nil
+	}
+	var version int
+	var err error
+	switch value := rawVersion.(type) {
+	case json.Number:
+		version, err = strconv.Atoi(value.String())
+	case float64:
+		version = int(value)
+		if float64(version) != value {
+			err = fmt.Errorf("%v is not an integer", value)
+		}
+	default:
+		err = fmt.Errorf("%v is not a number", value)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("invalid format version: %s", err)
+	} else if version < LegacyFormatVersion {
+		return 0, fmt.Errorf("invalid format version %d", version)
+	}
+	return version, nil
+}
+
+// migrateDefinition upgrades the given raw goPack definition to the current format version in place.
+func migrateDefinition(definition map[string]interface{}) error {
+	version, err := formatVersion(definition)
+	if err != nil {
+		return err
+	} else if version > CurrentFormatVersion {
+		return FormatError{FormatVersion: version}
+	}
+	for ; version < CurrentFormatVersion; version++ {
+		err = migrations[version-1](definition)
+		if err != nil {
+			return FormatError{FormatVersion: version, Err: err}
+		}
+	}
+	definition["format-version"] = json.Number(strconv.Itoa(CurrentFormatVersion))
+	return nil
+}
+
+// migrateGoPackedVersionRange replaces gopacked-version-minimum and gopacked-version-maximum with the equivalent
+// gopacked-version constraint (format version 1 to 2).
+func migrateGoPackedVersionRange(definition map[string]interface{}) error {
+	var terms []string
+	if constraint, ok := definition["gopacked-version"].(string); ok && len(constraint) != 0 {
+		terms = append(terms, constraint)
+	}
+	for _, field := range []string{"gopacked-version-minimum", "gopacked-version-maximum"} {
+		op := OpGreaterOrEqual
+		if field == "gopacked-version-maximum" {
+			op = OpSmallerOrEqual
+		}
+		rawVersion, ok := definition[field]
+		if !ok {
+			continue
+		}
+		delete(definition, field)
+		version, ok := rawVersion.(string)
+		if !ok {
+			return fmt.Errorf("%s is not a string", field)
+		} else if len(version) != 0 {
+			terms = append(terms, string(op)+version)
+		}
+	}
+	if len(terms) != 0 {
+		definition["gopacked-version"] = strings.Join(terms, " ")
+	}
+	return nil
+}
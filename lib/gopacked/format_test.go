@@ -0,0 +1,62 @@
+// goPacked - A simple text-based Minecraft modpack manager.
+// Copyright (C) 2019 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package gopacked
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestMigrateDefinition(t *testing.T) {
+	tests := []struct {
+		name       string
+		definition string
+		constraint string
+		// errVersion is the format version of the expected FormatError, or -1 for other errors.
+		errVersion int
+	}{
+		{"legacy range", `{"gopacked-version-minimum": "0.4", "gopacked-version-maximum": "0.6.2"}`, ">=0.4 <=0.6.2", 0},
+		{"legacy minimum", `{"format-version": 1, "gopacked-version-minimum": "0.4"}`, ">=0.4", 0},
+		{"legacy empty range", `{"gopacked-version-minimum": "", "gopacked-version-maximum": ""}`, "", 0},
+		{"legacy range and constraint", `{"gopacked-version": "!=0.5", "gopacked-version-maximum": "0.6"}`, "!=0.5 <=0.6", 0},
+		{"current", `{"format-version": 2, "gopacked-version": ">=0.7"}`, ">=0.7", 0},
+		{"invalid legacy range", `{"gopacked-version-minimum": 4}`, "", 1},
+		{"future format", `{"format-version": 99, "gopacked-version": ">=9.0"}`, "", 99},
+		{"invalid format", `{"format-version": "2"}`, "", -1},
+		{"zero format", `{"format-version": 0}`, "", -1},
+	}
+	for _, test := range tests {
+		var gp GoPack
+		err := json.Unmarshal([]byte(test.definition), &gp)
+		if test.errVersion == 0 {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %s", test.name, err)
+			} else if gp.FormatVersion != CurrentFormatVersion {
+				t.Errorf("%s: expected format version %d, got %d", test.name, CurrentFormatVersion, gp.FormatVersion)
+			} else if gp.GoPackedReq.String() != test.constraint {
+				t.Errorf("%s: expected constraint %q, got %q", test.name, test.constraint, gp.GoPackedReq)
+			}
+			continue
+		}
+		formatErr, ok := err.(FormatError)
+		if test.errVersion == -1 && (err == nil || ok) {
+			t.Errorf("%s: expected an invalid format version error, got %v", test.name, err)
+		} else if test.errVersion > 0 && (!ok || formatErr.FormatVersion != test.errVersion) {
+			t.Errorf("%s: expected a format error for version %d, got %v", test.name, test.errVersion, err)
+		}
+	}
+}
@@ -16,31 +16,51 @@
 
 package gopacked
 
-var GPVersion = Version{0, 4, 0}
+var GPVersion = Version("0.4.0")
 
 // GoPack is the base struct for a goPacked modpack.
 type GoPack struct {
-	Name        string                 `json:"name"`
-	SimpleName  string                 `json:"simplename"`
-	UpdateURL   string                 `json:"update-url"`
-	Author      string                 `json:"author"`
-	Version     Version                `json:"version"`
-	ForgeVer    string                 `json:"forge-version,omitempty"`
-	GoPackedMin Version                `json:"gopacked-version-minimum,omitempty"`
-	GoPackedMax Version                `json:"gopacked-version-maximum,omitempty"`
-	ProfileArgs map[string]interface{} `json:"profile-settings"`
-	MCLVersion  FileEntry              `json:"mcl-version"`
-	Files       FileEntry              `json:"files"`
+	// FormatVersion is the version of the definition format. It's always CurrentFormatVersion after the definition
+	// has been unmarshaled, as older formats are migrated.
+	FormatVersion int                    `json:"format-version"`
+	Name          string                 `json:"name"`
+	SimpleName    string                 `json:"simplename"`
+	UpdateURL     string                 `json:"update-url"`
+	Author        string                 `json:"author"`
+	Version       Version                `json:"version"`
+	MCVersion     string                 `json:"minecraft-version,omitempty"`
+	ForgeVer      string                 `json:"forge-version,omitempty"`
+	GoPackedReq   Constraint             `json:"gopacked-version,omitempty"`
+	SigningKey    string                 `json:"signing-key,omitempty"`
+	Extends       string                 `json:"extends,omitempty"`
+	Parent        *ParentInfo            `json:"parent,omitempty"`
+	Variables     map[string]string      `json:"variables,omitempty"`
+	ProfileArgs   map[string]interface{} `json:"profile-settings"`
+	MCLVersion    FileEntry              `json:"mcl-version"`
+	Files         FileEntry              `json:"files"`
+
+	// Source is the URL the definition was fetched from. Relative URLs in the definition are resolved against it.
+	// It's saved in the gopacked.json of installations, so that they keep the permissions of the original source.
+	Source string `json:"source,omitempty"`
 }
 
 type FileType string
 
 const (
-	TypeDirectory  FileType = "directory"
-	TypeFile                = "file"
-	TypeZipArchive          = "zip-archive"
+	TypeDirectory     FileType = "directory"
+	TypeFile          FileType = "file"
+	TypeZipArchive    FileType = "zip-archive"
+	TypeTarGzArchive  FileType = "tar-gz-archive"
+	TypeTarBz2Archive FileType = "tar-bz2-archive"
+	TypeInline        FileType = "inline"
+	TypePatch         FileType = "patch"
 )
 
+// IsArchive checks whether or not this file type is an archive that is extracted into a directory.
+func (ft FileType) IsArchive() bool {
+	return ft == TypeZipArchive || ft == TypeTarGzArchive || ft == TypeTarBz2Archive
+}
+
 type Side string
 
 const (
@@ -49,6 +69,53 @@ const (
 	SideBoth   Side = "both"
 )
 
+// UpdatePolicy determines what happens to files the user has modified when a file entry is updated.
+type UpdatePolicy string
+
+const (
+	// PolicyOverwrite replaces modified files with the new version. This is the default.
+	PolicyOverwrite UpdatePolicy = "overwrite"
+	// PolicyKeepIfModified keeps modified files as they are.
+	PolicyKeepIfModified UpdatePolicy = "keep-if-modified"
+	// PolicyMerge keeps modified files as well as files the user has added to the directory of an archive.
+	PolicyMerge UpdatePolicy = "merge"
+	// PolicyBackupThenOverwrite saves modified files (and files the user has added to the directory of an archive)
+	// with the .gopacked-old suffix and then replaces them.
+	PolicyBackupThenOverwrite UpdatePolicy = "backup-then-overwrite"
+)
+
+// ContentEncoding is the encoding of the content of an inline file entry.
+type ContentEncoding string
+
+const (
+	// EncodingUTF8 means the content is written as-is. This is the default.
+	EncodingUTF8 ContentEncoding = "utf-8"
+	// EncodingBase64 means the content is base64-encoded binary data.
+	EncodingBase64 ContentEncoding = "base64"
+)
+
+// PatchFormat is the format of a config file edited by a patch entry.
+type PatchFormat string
+
+const (
+	FormatJSON        PatchFormat = "json"
+	FormatTOML        PatchFormat = "toml"
+	FormatProperties  PatchFormat = "properties"
+	FormatForgeConfig PatchFormat = "forge-cfg"
+)
+
+// ExtractMode determines whether an archive entry owns the whole directory it's extracted to.
+type ExtractMode string
+
+const (
+	// ExtractReplace makes the archive own its directory, so the whole directory is replaced when the archive is
+	// updated and removed with the archive. This is the default.
+	ExtractReplace ExtractMode = "replace"
+	// ExtractMerge extracts the archive into a directory shared with other entries. Only the files that came from
+	// the archive are replaced or removed.
+	ExtractMerge ExtractMode = "merge"
+)
+
 // FileEntry contains the data of a file or directory.
 type FileEntry struct {
 	Type     FileType             `json:"type"`
@@ -56,5 +123,35 @@ type FileEntry struct {
 	Version  Version              `json:"version,omitempty"`
 	Side     Side                 `json:"side,omitempty"`
 	URL      string               `json:"url,omitempty"`
+	Mirrors  []string             `json:"mirrors,omitempty"`
+	SHA1     string               `json:"sha1,omitempty"`
+	SHA256   string               `json:"sha256,omitempty"`
+	SHA512   string               `json:"sha512,omitempty"`
+	Policy   UpdatePolicy         `json:"update-policy,omitempty"`
 	Children map[string]FileEntry `json:"children,omitempty"`
+
+	// Optional entries are only installed if the user selects them. Default determines whether they're selected
+	// by default and Description is shown when asking the user.
+	Optional    bool   `json:"optional,omitempty"`
+	Default     bool   `json:"default,omitempty"`
+	Description string `json:"description,omitempty"`
+
+	// Conditions restricts the entry to certain operating systems, architectures and other system properties.
+	Conditions *Conditions `json:"conditions,omitempty"`
+
+	// The content of inline entries.
+	Content  string          `json:"content,omitempty"`
+	Encoding ContentEncoding `json:"encoding,omitempty"`
+
+	// The changes made by patch entries.
+	Format PatchFormat            `json:"format,omitempty"`
+	Set    map[string]interface{} `json:"set,omitempty"`
+	Unset  []string               `json:"unset,omitempty"`
+
+	// Archive extraction options, only used by archive entries.
+	ExtractMode     ExtractMode `json:"extract-mode,omitempty"`
+	StripComponents int         `json:"strip-components,omitempty"`
+	ArchivePath     string      `json:"archive-path,omitempty"`
+	Include         []string    `json:"include,omitempty"`
+	Exclude         []string    `json:"exclude,omitempty"`
 }
@@ -0,0 +1,83 @@
+// goPacked - A simple text-based Minecraft modpack manager.
+// Copyright (C) 2019 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package gopacked
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// InlineContent returns the decoded content of an inline file entry.
+func (fe FileEntry) InlineContent() ([]byte, error) {
+	switch fe.Encoding {
+	case EncodingUTF8, "":
+		return []byte(fe.Content), nil
+	case EncodingBase64:
+		data, err := base64.StdEncoding.DecodeString(fe.Content)
+		if err != nil {
+			return nil, fmt.Errorf("invalid base64 content: %s", err)
+		}
+		return data, nil
+	default:
+		return nil, fmt.Errorf("unknown content encoding %s", fe.Encoding)
+	}
+}
+
+// contentHash returns the hex-encoded SHA-256 hash of the content of an inline file entry.
+func (fe FileEntry) contentHash() (string, error) {
+	data, err := fe.InlineContent()
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// contentChanged checks whether the content of this inline file entry differs from what was installed to the given
+// path. The hash in the install state is used if there is one, so that changes made by the user or patch entries
+// don't count.
+func (fe FileEntry) contentChanged(state *InstallState, path string) bool {
+	hash, err := fe.contentHash()
+	if err != nil {
+		return true
+	}
+	if record := state.Get(path); record != nil && len(record.UnpatchedSHA256) != 0 {
+		return record.UnpatchedSHA256 != hash
+	} else if record != nil {
+		return record.SHA256 != hash
+	}
+	_, installedHash, err := hashFile(path)
+	return err != nil || installedHash != hash
+}
+
+// writeInline writes the content of this inline file entry to the given path.
+func (fe FileEntry) writeInline(path string) error {
+	data, err := fe.InlineContent()
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
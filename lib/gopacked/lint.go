@@ -0,0 +1,303 @@
+// goPacked - A simple text-based Minecraft modpack manager.
+// Copyright (C) 2019 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package gopacked
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// LintProblem is a problem found in a goPack definition by Lint.
+type LintProblem struct {
+	// Path is the JSON path of the value that has the problem, e.g. $.files.children.mods.
+	Path    string
+	Message string
+}
+
+func (problem LintProblem) String() string {
+	return fmt.Sprintf("%s: %s", problem.Path, problem.Message)
+}
+
+// Lint checks the given goPack definition against DefinitionSchema and for mistakes the schema can't express, such
+// as fields that are ignored by the type of the file entry and file entries that would be installed to the same path.
+// Definitions in older formats are migrated before checking them.
+func Lint(data []byte) []LintProblem {
+	definition, err := decodeDefinition(data)
+	if err != nil {
+		return []LintProblem{{"$", fmt.Sprintf("invalid JSON: %s", err)}}
+	}
+	err = migrateDefinition(definition)
+	if err != nil {
+		return []LintProblem{{"$.format-version", err.Error()}}
+	}
+
+	validator, err := newSchemaValidator(DefinitionSchema)
+	if err != nil {
+		return []LintProblem{{"$", err.Error()}}
+	}
+	problems := validator.validate(validator.root, definition, "$")
+
+	if constraint, ok := definition["gopacked-version"].(string); ok {
+		_, err = ParseConstraint(constraint)
+		if err != nil {
+			problems = append(problems, LintProblem{"$.gopacked-version", err.Error()})
+		}
+	}
+	var gp GoPack
+	data, _ = json.Marshal(definition)
+	err = json.Unmarshal(data, &gp)
+	if err != nil {
+		// The schema problems already explain why the definition couldn't be parsed.
+		if len(problems) == 0 {
+			problems = append(problems, LintProblem{"$", err.Error()})
+		}
+		return problems
+	}
+
+	for _, name := range builtinVariables {
+		if _, ok := gp.Variables[name]; ok {
+			problems = append(problems, LintProblem{jsonPath("$.variables", name),
+				fmt.Sprintf("%s is a built-in variable and can't be overridden", name)})
+		}
+	}
+	// Paths are checked with the client-side values of the variables. Only the side variable differs between sides.
+	vars, err := gp.TemplateVariables(SideClient)
+	if err != nil {
+		problems = append(problems, LintProblem{"$.variables", err.Error()})
+	}
+
+	// The version files and the game directory are separate directories, so paths can't collide between them.
+	if raw, ok := definition["mcl-version"].(map[string]interface{}); ok {
+		linter := &entryLinter{vars: vars}
+		linter.lint(gp.MCLVersion, raw, "$.mcl-version", "", "", lintScope{})
+		problems = append(problems, linter.problems...)
+	}
+	if raw, ok := definition["files"].(map[string]interface{}); ok {
+		linter := &entryLinter{vars: vars}
+		linter.lint(gp.Files, raw, "$.files", "", "", lintScope{})
+		problems = append(problems, linter.problems...)
+	}
+	return problems
+}
+
+// typeSpecificFields contains the file entry fields that are only used by certain types of file entries.
+var typeSpecificFields = []struct {
+	field   string
+	usedBy  string
+	applies func(FileType) bool
+}{
+	{"url", "downloaded files and archives", isDownloaded},
+	{"mirrors", "downloaded files and archives", isDownloaded},
+	{"sha1", "downloaded files and archives", isDownloaded},
+	{"sha256", "downloaded files and archives", isDownloaded},
+	{"sha512", "downloaded files and archives", isDownloaded},
+	{"children", "directories", isType(TypeDirectory)},
+	{"content", "inline entries", isType(TypeInline)},
+	{"encoding", "inline entries", isType(TypeInline)},
+	{"format", "patch entries", isType(TypePatch)},
+	{"set", "patch entries", isType(TypePatch)},
+	{"unset", "patch entries", isType(TypePatch)},
+	{"extract-mode", "archives", FileType.IsArchive},
+	{"strip-components", "archives", FileType.IsArchive},
+	{"archive-path", "archives", FileType.IsArchive},
+	{"include", "archives", FileType.IsArchive},
+	{"exclude", "archives", FileType.IsArchive},
+}
+
+func isDownloaded(ft FileType) bool {
+	return ft == TypeFile || ft.IsArchive()
+}
+
+func isType(expected FileType) func(FileType) bool {
+	return func(ft FileType) bool {
+		return ft == expected
+	}
+}
+
+// lintScope contains the restrictions inherited from the parent directories of a file entry.
+type lintScope struct {
+	side Side
+	os   []string
+	arch []string
+}
+
+// narrow returns the scope of the given file entry inside this scope.
+func (scope lintScope) narrow(fe FileEntry) lintScope {
+	if scope.side == "" || scope.side == SideBoth {
+		scope.side = fe.Side
+	}
+	if fe.Conditions != nil {
+		scope.os = intersect(scope.os, normalize(fe.Conditions.OS, osAliases))
+		scope.arch = intersect(scope.arch, normalize(fe.Conditions.Arch, archAliases))
+	}
+	return scope
+}
+
+// overlaps checks whether there is a system where entries with both scopes would be installed.
+func (scope lintScope) overlaps(other lintScope) bool {
+	if scope.side != "" && scope.side != SideBoth && other.side != "" && other.side != SideBoth &&
+		scope.side != other.side {
+		return false
+	}
+	return (scope.os == nil || other.os == nil || len(intersect(scope.os, other.os)) != 0) &&
+		(scope.arch == nil || other.arch == nil || len(intersect(scope.arch, other.arch)) != 0)
+}
+
+func normalize(values []string, aliases map[string]string) []string {
+	if len(values) == 0 {
+		return nil
+	}
+	normalized := make([]string, len(values))
+	for i, value := range values {
+		value = strings.ToLower(value)
+		if alias, ok := aliases[value]; ok {
+			value = alias
+		}
+		normalized[i] = value
+	}
+	return normalized
+}
+
+// intersect returns the values that are in both lists, treating nil as a list of all values.
+func intersect(list1, list2 []string) []string {
+	if list1 == nil {
+		return list2
+	} else if list2 == nil {
+		return list1
+	}
+	result := []string{}
+	for _, value1 := range list1 {
+		for _, value2 := range list2 {
+			if value1 == value2 {
+				result = append(result, value1)
+			}
+		}
+	}
+	return result
+}
+
+// pathClaim is a path in the installation that a file entry writes to.
+type pathClaim struct {
+	path      string
+	jsonPath  string
+	directory bool
+	scope     lintScope
+}
+
+type entryLinter struct {
+	// vars contains the template variables, or nil if they couldn't be determined.
+	vars     map[string]string
+	claims   []pathClaim
+	problems []LintProblem
+}
+
+func (linter *entryLinter) add(jsonPath, message string, args ...interface{}) {
+	linter.problems = append(linter.problems, LintProblem{jsonPath, fmt.Sprintf(message, args...)})
+}
+
+func (linter *entryLinter) lint(fe FileEntry, raw map[string]interface{}, at, path, name string, scope lintScope) {
+	if linter.vars != nil {
+		expanded, err := fe.expandFields(linter.vars, nil)
+		if err != nil {
+			linter.add(at, "%s", err)
+		} else {
+			fe = expanded
+		}
+	}
+	for _, field := range typeSpecificFields {
+		if _, ok := raw[field.field]; ok && !field.applies(fe.Type) {
+			linter.add(jsonPath(at, field.field), "%s is only used by %s, not %s entries",
+				field.field, field.usedBy, fe.Type)
+		}
+	}
+	if fe.FileName == "//" {
+		// The children of the directory are installed directly in the parent directory.
+	} else if filepath.IsAbs(fe.FileName) || strings.IndexFunc(fe.FileName, isPathSeparator) == 0 {
+		linter.add(jsonPath(at, "filename"), "filename must be relative to the parent directory")
+	} else {
+		for _, part := range strings.FieldsFunc(fe.FileName, isPathSeparator) {
+			if part == ".." {
+				linter.add(jsonPath(at, "filename"), "filename must not point outside the parent directory")
+				break
+			}
+		}
+	}
+	if isDownloaded(fe.Type) && len(fe.URLs()) == 0 {
+		linter.add(at, "%s entry has no url", fe.Type)
+	} else if isDownloaded(fe.Type) && len(fe.Version) == 0 {
+		linter.add(at, "%s entry has no version, so it will never be updated", fe.Type)
+	}
+	if fe.Type == TypePatch && len(fe.Set) == 0 && len(fe.Unset) == 0 {
+		linter.add(at, "patch entry doesn't set or unset any keys")
+	}
+	if fe.Type == TypeInline {
+		if _, err := fe.InlineContent(); err != nil {
+			linter.add(jsonPath(at, "content"), "%s", err)
+		}
+	}
+	if fe.Default && !fe.Optional {
+		linter.add(jsonPath(at, "default"), "default is only used by optional entries")
+	}
+
+	scope = scope.narrow(fe)
+	entryPath := fe.path(path, name)
+	if fe.Type == TypeDirectory {
+		if entryPath != path {
+			linter.claim(pathClaim{entryPath, at, true, scope})
+		}
+		rawChildren, _ := raw["children"].(map[string]interface{})
+		childrenPath := jsonPath(at, "children")
+		names := make([]string, 0, len(fe.Children))
+		for childName := range fe.Children {
+			names = append(names, childName)
+		}
+		sort.Strings(names)
+		for _, childName := range names {
+			rawChild, _ := rawChildren[childName].(map[string]interface{})
+			linter.lint(fe.Children[childName], rawChild, jsonPath(childrenPath, childName), entryPath, childName, scope)
+		}
+	} else if fe.Type != TypePatch && !fe.merges() {
+		// Patches and merge-mode archives are meant to share their path with other entries.
+		linter.claim(pathClaim{entryPath, at, false, scope})
+	}
+}
+
+func isPathSeparator(r rune) bool {
+	return r == '/' || r == '\\'
+}
+
+// claim records that a file entry writes to the given path and reports conflicts with previous claims.
+func (linter *entryLinter) claim(claim pathClaim) {
+	claim.path = filepath.ToSlash(claim.path)
+	for _, other := range linter.claims {
+		if !claim.scope.overlaps(other.scope) {
+			continue
+		} else if other.path == claim.path {
+			if claim.directory && other.directory {
+				continue
+			}
+			linter.add(claim.jsonPath, "installed to the same path (%s) as %s", claim.path, other.jsonPath)
+		} else if strings.EqualFold(other.path, claim.path) {
+			linter.add(claim.jsonPath, "path %s only differs in case from %s (%s), which breaks on "+
+				"case-insensitive file systems like the ones on Windows and macOS", claim.path, other.path, other.jsonPath)
+		}
+	}
+	linter.claims = append(linter.claims, claim)
+}
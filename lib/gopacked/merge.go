@@ -0,0 +1,164 @@
+// goPacked - A simple text-based Minecraft modpack manager.
+// Copyright (C) 2019 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package gopacked
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"maunium.net/go/gopacked/lib/archive"
+	"maunium.net/go/gopacked/lib/log"
+)
+
+func (fe FileEntry) merges() bool {
+	return fe.Type.IsArchive() && fe.ExtractMode == ExtractMerge
+}
+
+func (fe FileEntry) extractMode() ExtractMode {
+	if len(fe.ExtractMode) == 0 {
+		return ExtractReplace
+	}
+	return fe.ExtractMode
+}
+
+// planMerge adds this merge-mode archive entry to the download plan. The archive is extracted into a temporary
+// directory and the extracted files are then moved into the directory at target one by one, so that the files of
+// other entries in the directory aren't touched. The files that came from the previous version of the archive are
+// replaced or removed according to the update policy of the entry.
+func (fe FileEntry) planMerge(dl *Downloader, tx *Transaction, target, name, message string) {
+	oldState := dl.State.Copy()
+	previous := dl.State.Owned(target, name)
+	for _, key := range previous {
+		dl.State.Forget(dl.State.Path(key))
+	}
+
+	parent := filepath.Dir(target)
+	if tx != nil {
+		parent = filepath.Dir(tx.Stage(target))
+	}
+	err := os.MkdirAll(parent, 0755)
+	if err != nil {
+		log.Warnf("Failed to create directory for %[1]s: %[2]s", name, err)
+		return
+	}
+	workDir, err := ioutil.TempDir(parent, "."+filepath.Base(target)+".gopacked-merge")
+	if err != nil {
+		log.Warnf("Failed to create temporary directory for %[1]s: %[2]s", name, err)
+		return
+	}
+	archivePath := filepath.Join(workDir, "archive")
+	extractPath := filepath.Join(workDir, "files")
+	task := &DownloadTask{
+		Name:    name,
+		Message: message,
+		Entry:   fe,
+		Path:    archivePath,
+		Target:  target,
+		Dir:     extractPath,
+	}
+	task.Finish = func() error {
+		if tx == nil {
+			// Without a transaction the files are moved into place right away, so the work directory isn't needed
+			// afterwards. Transactions keep it in the staging directory until the transaction is cleaned up.
+			defer func() {
+				err := os.RemoveAll(workDir)
+				if err != nil {
+					log.Warnf("Failed to remove temporary directory %[1]s: %[2]s", workDir, err)
+				}
+			}()
+		}
+		err := fe.extract(archivePath, extractPath)
+		if archive.IsUnsafePath(err) {
+			return fmt.Errorf("refusing to extract: %s", err)
+		} else if err != nil {
+			return fmt.Errorf("failed to extract: %s", err)
+		}
+		task.Files, err = listFiles(extractPath)
+		if err != nil {
+			return fmt.Errorf("failed to list extracted files: %s", err)
+		}
+		err = fe.merge(oldState, tx, extractPath, target, task.Files, previous)
+		if err != nil {
+			return fmt.Errorf("failed to merge files into %s: %s", target, err)
+		}
+		if tx == nil {
+			task.Dir = target
+		}
+		return nil
+	}
+	dl.Add(task)
+}
+
+// merge moves the given files from dir to target, or schedules them to be moved when the transaction is committed.
+// The files from the previous version of the archive that aren't in the new version are removed. If a transaction is
+// given, the update policy of the entry is applied to previously extracted files that the user has modified.
+func (fe FileEntry) merge(state *InstallState, tx *Transaction, dir, target string, files, previous []string) error {
+	merged := make(map[string]bool, len(files))
+	for _, file := range files {
+		merged[file] = true
+		from := filepath.Join(dir, filepath.FromSlash(file))
+		to := filepath.Join(target, filepath.FromSlash(file))
+		if tx == nil {
+			err := os.MkdirAll(filepath.Dir(to), 0755)
+			if err != nil {
+				return err
+			}
+			err = os.Rename(from, to)
+			if err != nil {
+				return err
+			}
+		} else if fe.preserveFile(state, tx, to, file) {
+			tx.Replace(from, to)
+		}
+	}
+	for _, key := range previous {
+		path := state.Path(key)
+		rel, err := filepath.Rel(target, path)
+		if err != nil || merged[filepath.ToSlash(rel)] {
+			continue
+		}
+		if tx == nil {
+			err = os.Remove(path)
+			if err != nil && !os.IsNotExist(err) {
+				log.Warnf("Failed to remove %[1]s: %[2]s", path, err)
+			}
+		} else if fe.preserveFile(state, tx, path, filepath.ToSlash(rel)) {
+			log.Infof("Removing %[1]s, as it's no longer in the archive", path)
+			tx.Remove(path)
+		}
+	}
+	return nil
+}
+
+// listFiles returns the slash-separated paths of all the non-directory files inside the given directory.
+func listFiles(dir string) ([]string, error) {
+	files := make([]string, 0)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	})
+	return files, err
+}
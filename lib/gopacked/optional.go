@@ -0,0 +1,162 @@
+// goPacked - A simple text-based Minecraft modpack manager.
+// Copyright (C) 2019 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package gopacked
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"maunium.net/go/gopacked/lib/log"
+)
+
+// ChoicesFileName is the name of the file next to gopacked.json where the selected optional components are saved.
+const ChoicesFileName = "gopacked-choices.json"
+
+// Choices contains the optional components the user has selected (true) or deselected (false).
+// The keys are the IDs of the components.
+type Choices map[string]bool
+
+// Component is an optional file entry that the user can choose whether to install.
+type Component struct {
+	// ID is the slash-separated path of display names from the root file entry, e.g. mods/Minimap.
+	ID    string
+	Name  string
+	Entry FileEntry
+}
+
+// LoadChoices loads the choices saved in the installation at the given path.
+// If no choices have been saved, an empty map is returned.
+func LoadChoices(path string) (Choices, error) {
+	choices := make(Choices)
+	data, err := ioutil.ReadFile(filepath.Join(path, ChoicesFileName))
+	if os.IsNotExist(err) {
+		return choices, nil
+	} else if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, &choices)
+	return choices, err
+}
+
+// Save saves the choices to the given file.
+func (choices Choices) Save(path string) error {
+	return writeJSON(choices, path)
+}
+
+// Selected checks whether the given component should be installed.
+func (choices Choices) Selected(component Component) bool {
+	selected, ok := choices[component.ID]
+	if !ok {
+		return component.Entry.Default
+	}
+	return selected
+}
+
+// Choose selects or deselects the components with the given IDs or display names.
+func (choices Choices) Choose(components []Component, names []string, selected bool) error {
+	for _, name := range names {
+		found := false
+		for _, component := range components {
+			if strings.EqualFold(component.ID, name) || strings.EqualFold(component.Name, name) {
+				choices[component.ID] = selected
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("unknown optional component %s", name)
+		}
+	}
+	return nil
+}
+
+// Prompt asks the user whether to install each of the given components that hasn't been chosen yet.
+func (choices Choices) Prompt(components []Component) {
+	for _, component := range components {
+		if _, ok := choices[component.ID]; ok {
+			continue
+		}
+		options := "[y/N]"
+		if component.Entry.Default {
+			options = "[Y/n]"
+		}
+		if len(component.Entry.Description) != 0 {
+			log.Infof("%[1]s: %[2]s", component.ID, component.Entry.Description)
+		}
+		answer := strings.ToLower(strings.TrimSpace(log.Inputf("Install optional component %s? %s", component.ID, options)))
+		if len(answer) == 0 {
+			choices[component.ID] = component.Entry.Default
+		} else {
+			choices[component.ID] = answer[0] == 'y'
+		}
+	}
+}
+
+// Components returns the optional file entries in this file entry tree that apply to the given side,
+// sorted by ID.
+func (fe FileEntry) Components(side Side) []Component {
+	var components []Component
+	fe.findComponents("", side, &components)
+	sort.Slice(components, func(i, j int) bool {
+		return components[i].ID < components[j].ID
+	})
+	return components
+}
+
+func (fe FileEntry) findComponents(id string, side Side, components *[]Component) {
+	for key, value := range fe.Children {
+		if !value.applies(side) {
+			continue
+		}
+		childID := key
+		if len(id) != 0 {
+			childID = id + "/" + key
+		}
+		if value.Optional {
+			*components = append(*components, Component{ID: childID, Name: key, Entry: value})
+		}
+		value.findComponents(childID, side, components)
+	}
+}
+
+// Select returns a copy of this file entry tree without the optional entries that aren't selected.
+func (fe FileEntry) Select(choices Choices) FileEntry {
+	return fe.selectChildren("", choices)
+}
+
+func (fe FileEntry) selectChildren(id string, choices Choices) FileEntry {
+	if len(fe.Children) == 0 {
+		return fe
+	}
+	children := make(map[string]FileEntry, len(fe.Children))
+	for key, value := range fe.Children {
+		childID := key
+		if len(id) != 0 {
+			childID = id + "/" + key
+		}
+		if value.Optional && !choices.Selected(Component{ID: childID, Name: key, Entry: value}) {
+			continue
+		}
+		children[key] = value.selectChildren(childID, choices)
+	}
+	fe.Children = children
+	return fe
+}
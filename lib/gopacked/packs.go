@@ -106,7 +106,7 @@ func (gp GoPack) InstallForge(path, mcPath string, side Side) {
 
 	installerURL := fmt.Sprintf("http://files.minecraftforge.net/maven/net/minecraftforge/forge/%[1]s/forge-%[1]s-installer.jar", gp.ForgeVer)
 	installerPath := filepath.Join(path, "forge-installer.jar")
-	err := downloadFile(installerURL, installerPath)
+	err := downloadFile(HTTPClient, installerURL, installerPath, true)
 	if err != nil {
 		log.Errorf("Failed to download Forge installer: %s", err)
 		return
@@ -137,35 +137,28 @@ func (gp GoPack) InstallForge(path, mcPath string, side Side) {
 
 // CheckVersion checks whether or not the goPacked instance is within the version requirements of this goPack.
 func (gp GoPack) CheckVersion() bool {
-	var continueAsk = false
-	if len(gp.GoPackedMax) != 0 {
-		if gp.GoPackedMax.Compare(GPVersion) == 1 {
-			log.Warnf("goPacked version greater than maximum supported by requested goPack")
-			continueAsk = true
-		}
-	}
-	if len(gp.GoPackedMin) != 0 {
-		if gp.GoPackedMin.Compare(GPVersion) == -1 {
-			log.Warnf("goPacked version smaller than minimum supported by requested goPack")
-			continueAsk = true
-		}
-	}
-	if continueAsk {
+	if !gp.GoPackedReq.Match(GPVersion) {
+		log.Warnf("goPacked v%[1]s is not supported by the requested goPack (requires %[2]s)", GPVersion, gp.GoPackedReq)
 		linec := []rune(log.Inputf("Would you like to continue anyway [y/N]"))
-		if linec[0] != 'y' && linec[0] != 'Y' {
+		if len(linec) == 0 || (linec[0] != 'y' && linec[0] != 'Y') {
 			return false
 		}
 	}
 	return true
 }
 
-// Install installs the GoPack to the given path and minecraft directory.
-func (gp GoPack) Install(path, mcPath string, side Side) {
+// Install installs the GoPack to the given path and minecraft directory using the given Downloader.
+// Optional file entries are only installed if they're selected in the given choices.
+func (gp GoPack) Install(dl *Downloader, choices Choices, path, mcPath string, side Side) {
 	if !gp.CheckVersion() {
 		return
 	}
+	expanded, err := gp.Expand(side)
+	if err != nil {
+		log.Errorf("Failed to expand template variables: %s", err)
+		return
+	}
 
-	var err error
 	path, err = filepath.Abs(path)
 	if err != nil {
 		log.Warnf("Failed to get absolute path of %s: %s", path, err)
@@ -182,6 +175,11 @@ func (gp GoPack) Install(path, mcPath string, side Side) {
 	}
 
 	log.Infof("Installing %[1]s v%[2]s by %[3]s to %[4]s (%[5]s-side)", gp.Name, gp.Version, gp.Author, path, side)
+	if gp.Parent != nil {
+		log.Infof("%[1]s is based on %[2]s v%[3]s", gp.Name, gp.Parent.Name, gp.Parent.Version)
+	}
+
+	dl.State = NewInstallState(path)
 
 	if side == SideClient {
 		err = gp.InstallProfile(path, mcPath)
@@ -189,9 +187,11 @@ func (gp GoPack) Install(path, mcPath string, side Side) {
 			log.Errorf("Profile install failed: %s", err)
 		}
 
-		gp.MCLVersion.Install(filepath.Join(mcPath, "versions", gp.SimpleName), "", side)
+		expanded.MCLVersion.Install(dl, filepath.Join(mcPath, "versions", gp.SimpleName), "", side)
 	}
-	gp.Files.Install(path, "", side)
+	expanded.Files.Select(choices).Install(dl, path, "", side)
+	ReportErrors(dl.Run())
+	ReportErrors(dl.ApplyPatches(nil))
 	gp.InstallForge(path, mcPath, side)
 
 	log.Infof("Saving goPack definition to %s", filepath.Join(path, "gopacked.json"))
@@ -199,15 +199,36 @@ func (gp GoPack) Install(path, mcPath string, side Side) {
 	if err != nil {
 		log.Errorf("goPack definition save failed: %s", err)
 	}
+	err = dl.State.Save(filepath.Join(path, StateFileName))
+	if err != nil {
+		log.Errorf("Install state save failed: %s", err)
+	}
+	err = choices.Save(filepath.Join(path, ChoicesFileName))
+	if err != nil {
+		log.Errorf("Optional component choices save failed: %s", err)
+	}
 }
 
-// Update this GoPack.
-func (gp GoPack) Update(new GoPack, path, mcPath string, side Side) {
+// Update this GoPack using the given Downloader. The update is done in a transaction: all the new files are first
+// downloaded and patched in staging directories and only moved into place once everything has been downloaded and
+// verified. If any step fails, the previous files and launcher profile are restored. The given choices replace the
+// previously saved choices of optional file entries, so deselected entries are removed and newly selected ones are
+// installed.
+func (gp GoPack) Update(dl *Downloader, new GoPack, choices Choices, path, mcPath string, side Side) {
 	if !new.CheckVersion() {
 		return
 	}
+	oldExpanded, err := gp.Expand(side)
+	if err != nil {
+		log.Errorf("Failed to expand template variables of installed goPack: %s", err)
+		return
+	}
+	newExpanded, err := new.Expand(side)
+	if err != nil {
+		log.Errorf("Failed to expand template variables: %s", err)
+		return
+	}
 
-	var err error
 	path, err = filepath.Abs(path)
 	if err != nil {
 		log.Warnf("Failed to get absolute version of %s: %s", path, err)
@@ -217,24 +238,119 @@ func (gp GoPack) Update(new GoPack, path, mcPath string, side Side) {
 		log.Warnf("Failed to get absolute version of %s: %s", mcPath, err)
 	}
 
-	log.Infof("Updating %[1]s by %[3]s to v%[2]s (%[4]s-side)", gp.Name, gp.Version, gp.Author, side)
+	log.Infof("Updating %[1]s by %[3]s to v%[2]s (%[4]s-side)", gp.Name, new.Version, gp.Author, side)
+	if new.Parent != nil {
+		log.Infof("%[1]s is based on %[2]s v%[3]s", new.Name, new.Parent.Name, new.Parent.Version)
+	}
+
+	dl.State, err = LoadInstallState(path)
+	if err != nil {
+		log.Warnf("Failed to read install state, checking files on disk instead: %s", err)
+		dl.State = NewInstallState(path)
+		dl.State.Legacy = true
+	}
 
+	oldChoices, err := LoadChoices(path)
+	if err != nil {
+		log.Warnf("Failed to read optional component choices: %s", err)
+		oldChoices = make(Choices)
+	}
+
+	tx := NewTransaction(path, filepath.Join(mcPath, "versions"))
 	if side == SideClient {
-		err = gp.InstallProfile(path, mcPath)
+		oldExpanded.MCLVersion.Update(dl, tx, newExpanded.MCLVersion, filepath.Join(mcPath, "versions", gp.SimpleName), filepath.Join(mcPath, "versions", new.SimpleName), "", side)
+	}
+	oldExpanded.Files.Select(oldChoices).Update(dl, tx, newExpanded.Files.Select(choices), path, path, "", side)
+
+	definitionPath := filepath.Join(path, "gopacked.json")
+	stagedDefinition := tx.Stage(definitionPath)
+	err = new.Save(stagedDefinition)
+	if err != nil {
+		log.Errorf("goPack definition save failed: %s", err)
+		tx.Cleanup()
+		return
+	}
+	tx.Replace(stagedDefinition, definitionPath)
+
+	choicesPath := filepath.Join(path, ChoicesFileName)
+	stagedChoices := tx.Stage(choicesPath)
+	err = choices.Save(stagedChoices)
+	if err != nil {
+		log.Errorf("Optional component choices save failed: %s", err)
+		tx.Cleanup()
+		return
+	}
+	tx.Replace(stagedChoices, choicesPath)
+
+	if ReportErrors(dl.Run()) || ReportErrors(dl.ApplyPatches(tx)) {
+		log.Errorf("Update cancelled, no changes were made to the installation")
+		tx.Cleanup()
+		return
+	}
+
+	statePath := filepath.Join(path, StateFileName)
+	stagedState := tx.Stage(statePath)
+	err = dl.State.Save(stagedState)
+	if err != nil {
+		log.Errorf("Install state save failed: %s", err)
+		tx.Cleanup()
+		return
+	}
+	tx.Replace(stagedState, statePath)
+
+	var restoreProfile func()
+	if side == SideClient {
+		restoreProfile, err = backupFile(filepath.Join(mcPath, "launcher_profiles.json"))
+		if err != nil {
+			log.Errorf("Failed to back up launcher_profiles.json: %s", err)
+			log.Errorf("Update cancelled, no changes were made to the installation")
+			tx.Cleanup()
+			return
+		}
+		if gp.Name != new.Name {
+			err = gp.UninstallProfile(path, mcPath)
+			if err != nil {
+				log.Warnf("Failed to remove old profile: %s", err)
+			}
+		}
+		err = new.InstallProfile(path, mcPath)
 		if err != nil {
 			log.Errorf("Profile install failed: %s", err)
+			log.Errorf("Update cancelled, no changes were made to the installation")
+			restoreProfile()
+			tx.Cleanup()
+			return
 		}
+	}
 
-		gp.MCLVersion.Update(new.MCLVersion, filepath.Join(mcPath, "versions", gp.SimpleName), filepath.Join(mcPath, "versions", new.SimpleName), "", side)
+	log.Infof("Moving updated files into place")
+	err = tx.Commit()
+	if err != nil {
+		log.Errorf("Failed to apply update: %s", err)
+		log.Errorf("The previous version of %s has been restored", gp.Name)
+		if restoreProfile != nil {
+			restoreProfile()
+		}
+		return
 	}
-	gp.Files.Update(new.Files, path, path, "", side)
-	gp.InstallForge(path, mcPath, side)
+	tx.Cleanup()
 
-	log.Infof("Saving goPack definition to %s", filepath.Join(path, "gopacked.json"))
-	err = new.Save(filepath.Join(path, "gopacked.json"))
+	new.InstallForge(path, mcPath, side)
+	log.Infof("Update finished")
+}
+
+// backupFile reads the given file and returns a function that restores the file to its current state.
+func backupFile(path string) (func(), error) {
+	data, err := ioutil.ReadFile(path)
 	if err != nil {
-		log.Errorf("goPack definition save failed: %s", err)
+		return nil, err
 	}
+	return func() {
+		err := ioutil.WriteFile(path, data, 0644)
+		if err != nil {
+			log.Errorf("Failed to restore %[1]s: %[2]s", path, err)
+		}
+	}, nil
 }
 
 // Uninstall this GoPack.
@@ -256,6 +372,23 @@ func (gp GoPack) Uninstall(path, mcPath string, side Side) {
 	}
 
 	log.Infof("Uninstalling %[1]s v%[2]s by %[3]s from %[4]s (%[5]s-side)", gp.Name, gp.Version, gp.Author, path, side)
+	expanded, err := gp.Expand(side)
+	if err != nil {
+		log.Warnf("Failed to expand template variables: %s", err)
+		expanded = gp
+	}
+
+	state, err := LoadInstallState(path)
+	if err != nil {
+		log.Warnf("Failed to read install state: %s", err)
+	} else {
+		for _, key := range state.Keys() {
+			err = os.Remove(state.Path(key))
+			if err != nil && !os.IsNotExist(err) {
+				log.Warnf("Failed to remove %s: %s", state.Path(key), err)
+			}
+		}
+	}
 
 	if side == SideClient {
 		err = gp.UninstallProfile(path, mcPath)
@@ -263,17 +396,121 @@ func (gp GoPack) Uninstall(path, mcPath string, side Side) {
 			log.Errorf("Profile uninstall failed: %s", err)
 		}
 
-		gp.MCLVersion.Remove(filepath.Join(mcPath, "versions", gp.SimpleName), "", side)
+		expanded.MCLVersion.Remove(state, filepath.Join(mcPath, "versions", gp.SimpleName), "", side)
 	}
-	gp.Files.Remove(path, "", side)
+	expanded.Files.Remove(state, path, "", side)
 	err = os.RemoveAll(path)
 	if err != nil {
 		log.Warnf("Failed to remove %s: %s", path, err)
 	}
 }
 
-// Save saves the gopack definion to the given path.
+// Verify compares the installed files against this GoPack and returns the files that are missing, changed or unexpected.
+func (gp GoPack) Verify(path, mcPath string, side Side) []Problem {
+	var err error
+	path, err = filepath.Abs(path)
+	if err != nil {
+		log.Warnf("Failed to get absolute version of %s: %s", path, err)
+	}
+	mcPath, err = filepath.Abs(mcPath)
+	if err != nil {
+		log.Warnf("Failed to get absolute version of %s: %s", mcPath, err)
+	}
+
+	state, err := LoadInstallState(path)
+	if err != nil {
+		log.Warnf("Failed to read install state, only checking for missing files: %s", err)
+		state = NewInstallState(path)
+	}
+
+	choices, err := LoadChoices(path)
+	if err != nil {
+		log.Warnf("Failed to read optional component choices: %s", err)
+	}
+
+	expanded, err := gp.Expand(side)
+	if err != nil {
+		log.Warnf("Failed to expand template variables: %s", err)
+		expanded = gp
+	}
+
+	log.Infof("Verifying %[1]s v%[2]s in %[3]s (%[4]s-side)", gp.Name, gp.Version, path, side)
+	var problems []Problem
+	if side == SideClient {
+		problems = append(problems, expanded.MCLVersion.Verify(state, filepath.Join(mcPath, "versions", gp.SimpleName), "", side)...)
+	}
+	problems = append(problems, expanded.Files.Select(choices).Verify(state, path, "", side)...)
+	return problems
+}
+
+// Repair re-downloads the files that are missing or changed and optionally removes unexpected files.
+func (gp GoPack) Repair(dl *Downloader, path, mcPath string, side Side) {
+	problems := gp.Verify(path, mcPath, side)
+	if len(problems) == 0 {
+		log.Infof("No problems found")
+		return
+	}
+
+	var err error
+	path, err = filepath.Abs(path)
+	if err != nil {
+		log.Warnf("Failed to get absolute version of %s: %s", path, err)
+	}
+	// Installations without a state file get a legacy state, but an unreadable state file can't be replaced with
+	// one that only contains the repaired files.
+	dl.State, err = LoadInstallState(path)
+	if err != nil {
+		log.Errorf("Failed to read install state: %s", err)
+		log.Errorf("Repair cancelled, fix or remove %s and try again", filepath.Join(path, StateFileName))
+		return
+	}
+
+	var unexpected []string
+	// Patch entries have the same path as the entry of the file they patch, so entries are identified by the name too.
+	repaired := make(map[[2]string]bool)
+	for _, problem := range problems {
+		entry := [2]string{problem.EntryPath, problem.Name}
+		if problem.Type == ProblemUnexpected {
+			log.Warnf("Unexpected file %s", problem.Path)
+			unexpected = append(unexpected, problem.Path)
+			continue
+		} else if repaired[entry] {
+			continue
+		}
+		repaired[entry] = true
+		log.Warnf("%[1]s is %[2]s, reinstalling %[3]s", problem.Path, problem.Type, problem.Name)
+		problem.Entry.backupModified(dl.State, problem.EntryPath, problem.Name)
+		problem.Entry.install(dl, nil, problem.EntryPath, problem.Name, side)
+	}
+	ReportErrors(dl.Run())
+	ReportErrors(dl.ApplyPatches(nil))
+
+	if len(unexpected) > 0 {
+		linec := []rune(log.Inputf("Would you like to remove the %d unexpected files [y/N] ", len(unexpected)))
+		if len(linec) > 0 && (linec[0] == 'y' || linec[0] == 'Y') {
+			for _, file := range unexpected {
+				err = os.Remove(file)
+				if err != nil {
+					log.Warnf("Failed to remove %s: %s", file, err)
+				}
+			}
+		}
+	}
+
+	// Legacy installations only have a partial state at this point, so saving it would make
+	// the rest of the files look like they're not installed.
+	if !dl.State.Legacy {
+		err = dl.State.Save(filepath.Join(path, StateFileName))
+		if err != nil {
+			log.Errorf("Install state save failed: %s", err)
+		}
+	}
+	log.Infof("Repair finished")
+}
+
+// Save saves the gopack definion to the given path in the current format.
 func (gp GoPack) Save(path string) error {
+	gp.FormatVersion = CurrentFormatVersion
 	data, err := json.Marshal(gp)
 	if err != nil {
 		return fmt.Errorf("failed to marshal: %s", err)
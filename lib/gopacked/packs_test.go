@@ -0,0 +1,45 @@
+// goPacked - A simple text-based Minecraft modpack manager.
+// Copyright (C) 2019 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package gopacked
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRepairUnreadableState(t *testing.T) {
+	server := zipServer(t, map[string]map[string]string{"/v1.zip": {"a.cfg": "a=1"}})
+	defer server.Close()
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	gp := archivePack("1", server.URL+"/v1.zip", "")
+	gp.Install(NewDownloader(1, 1), nil, dir, dir, SideServer)
+	statePath := filepath.Join(dir, StateFileName)
+	if err := ioutil.WriteFile(statePath, []byte("{corrupted"), 0644); err != nil {
+		t.Fatal(err)
+	} else if err = os.RemoveAll(filepath.Join(dir, "cfgdir")); err != nil {
+		t.Fatal(err)
+	}
+
+	gp.Repair(NewDownloader(1, 1), dir, dir, SideServer)
+	if content := readString(t, statePath); content != "{corrupted" {
+		t.Errorf("unreadable state file was overwritten: %q", content)
+	}
+}
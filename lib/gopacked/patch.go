@@ -0,0 +1,229 @@
+// goPacked - A simple text-based Minecraft modpack manager.
+// Copyright (C) 2019 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package gopacked
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"maunium.net/go/gopacked/lib/log"
+)
+
+// patchOp is a planned application or reversion of a patch entry.
+type patchOp struct {
+	entry  FileEntry
+	path   string
+	name   string
+	revert bool
+}
+
+func (dl *Downloader) addPatch(entry FileEntry, path, name string, revert bool) {
+	dl.patches = append(dl.patches, patchOp{entry, path, name, revert})
+}
+
+// ApplyPatches applies the planned patch entries and reverts the ones that were removed. It must be called after the
+// downloaded files are in place, or with the transaction the files were staged in, as patches can edit files that
+// were downloaded or extracted in the same plan. With a transaction, the patched files are staged as well, so they're
+// only changed when the transaction is committed. Like Run, it returns the errors of the failed patches and clears
+// the plan.
+func (dl *Downloader) ApplyPatches(tx *Transaction) (errors []error) {
+	patches := dl.patches
+	dl.patches = nil
+	// Reverts go first, so that a patch that moved to another entry isn't reverted after being applied again.
+	sort.SliceStable(patches, func(i, j int) bool {
+		return patches[i].revert && !patches[j].revert
+	})
+	for _, op := range patches {
+		err := dl.runPatch(tx, op)
+		if err != nil {
+			errors = append(errors, DownloadError{op.name, err})
+		}
+	}
+	return
+}
+
+func (dl *Downloader) runPatch(tx *Transaction, op patchOp) error {
+	if op.revert && len(dl.State.PatchedKeys(op.path, op.name)) == 0 {
+		return nil
+	}
+	file, replace, err := stagePatch(tx, op.path)
+	if err != nil {
+		return err
+	}
+	if op.revert {
+		log.Infof("Reverting %[1]s in %[2]s", op.name, op.path)
+		err = op.entry.revertPatch(dl.State, file, op.path, op.name)
+	} else {
+		log.Infof("Patching %[1]s with %[2]s", op.path, op.name)
+		err = op.entry.applyPatch(dl.State, file, op.path, op.name)
+	}
+	if err != nil {
+		return err
+	} else if _, err = os.Stat(file); os.IsNotExist(err) {
+		// Reverting a patch of a file that no longer exists doesn't create it.
+		return nil
+	}
+	if replace {
+		tx.Replace(file, op.path)
+	}
+	// The patched file is recorded, so that the patch isn't detected as a modification of the file.
+	return dl.State.Rehash(file, op.path)
+}
+
+// stagePatch returns the path of the file a patch to the file at target should edit. Without a transaction, that's
+// the target itself. If the transaction already has a new version of the file staged, the staged file is edited.
+// Otherwise the current file is copied into the staging directory, and replace is true, as the staged file must be
+// scheduled to replace the target.
+func stagePatch(tx *Transaction, target string) (file string, replace bool, err error) {
+	if tx == nil {
+		return target, false, nil
+	}
+	staged, ok := tx.Staged(target)
+	if ok && len(staged) != 0 {
+		return staged, false, nil
+	}
+	staged = tx.Stage(target)
+	if !ok {
+		err = copyFile(target, staged)
+		if err != nil && !os.IsNotExist(err) {
+			return "", false, fmt.Errorf("failed to stage %s: %s", target, err)
+		}
+	}
+	return staged, true, nil
+}
+
+// patchFormat returns the format of the config file at path, either from the format field or the file extension.
+func (fe FileEntry) patchFormat(path string) PatchFormat {
+	if len(fe.Format) != 0 {
+		return fe.Format
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return FormatJSON
+	case ".toml":
+		return FormatTOML
+	case ".cfg":
+		return FormatForgeConfig
+	default:
+		return FormatProperties
+	}
+}
+
+// sameValue checks whether a config key with the given current value has the value a patch set.
+func sameValue(current interface{}, exists bool, patched *PatchedKey) bool {
+	if patched.Value == nil {
+		return !exists
+	}
+	return exists && reflect.DeepEqual(current, patched.Value)
+}
+
+// applyPatch applies this patch entry to the config file at path and records the original values of the changed keys
+// as the keys of the file at target. Keys changed by a previous version of the patch that are no longer changed are
+// reverted.
+func (fe FileEntry) applyPatch(state *InstallState, path, target, name string) error {
+	format := fe.patchFormat(target)
+	cfg, err := loadConfigFile(format, path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %s", target, err)
+	}
+	previous := state.PatchedKeys(target, name)
+	patched := make(map[string]*PatchedKey)
+
+	changes := make(map[string]interface{}, len(fe.Set)+len(fe.Unset))
+	for _, key := range fe.Unset {
+		changes[key] = nil
+	}
+	for key, value := range fe.Set {
+		changes[key], err = format.encodeValue(value)
+		if err != nil {
+			return fmt.Errorf("invalid value for %s: %s", key, err)
+		}
+	}
+
+	for key, value := range changes {
+		current, exists := cfg.Get(key)
+		record := &PatchedKey{Entry: name, Original: current, Existed: exists, Value: value}
+		if prev, ok := previous[key]; ok {
+			if sameValue(current, exists, prev) {
+				// The key still has the value from the previous version of the patch, so the original value
+				// from before that is what should be restored when reverting.
+				record.Original, record.Existed = prev.Original, prev.Existed
+			} else if fe.keepsModified() {
+				log.Infof("Keeping %[1]s in %[2]s, as it has been modified", key, target)
+				patched[key] = prev
+				continue
+			}
+		}
+		if value == nil {
+			cfg.Unset(key)
+		} else if err = cfg.Set(key, value); err != nil {
+			return fmt.Errorf("failed to set %s: %s", key, err)
+		}
+		patched[key] = record
+	}
+	for key, prev := range previous {
+		if _, ok := changes[key]; !ok {
+			revertKey(cfg, key, prev)
+		}
+	}
+
+	err = cfg.Save(path)
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %s", target, err)
+	}
+	state.SetPatchedKeys(target, name, patched)
+	return nil
+}
+
+// revertPatch restores the original values of the config keys this patch entry changed in the file at target,
+// editing the config file at path. Keys that have been changed since the patch was applied are left alone.
+func (fe FileEntry) revertPatch(state *InstallState, path, target, name string) error {
+	previous := state.PatchedKeys(target, name)
+	if len(previous) == 0 {
+		return nil
+	} else if _, err := os.Stat(path); os.IsNotExist(err) {
+		state.SetPatchedKeys(target, name, nil)
+		return nil
+	}
+	cfg, err := loadConfigFile(fe.patchFormat(target), path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %s", target, err)
+	}
+	for key, prev := range previous {
+		revertKey(cfg, key, prev)
+	}
+	err = cfg.Save(path)
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %s", target, err)
+	}
+	state.SetPatchedKeys(target, name, nil)
+	return nil
+}
+
+func revertKey(cfg configFile, key string, prev *PatchedKey) {
+	if current, exists := cfg.Get(key); !sameValue(current, exists, prev) {
+		return
+	} else if prev.Existed {
+		_ = cfg.Set(key, prev.Original)
+	} else {
+		cfg.Unset(key)
+	}
+}
@@ -0,0 +1,168 @@
+// goPacked - A simple text-based Minecraft modpack manager.
+// Copyright (C) 2019 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package gopacked
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func readString(t *testing.T, path string) string {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestApplyPatchesInTransaction(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	writeFiles(t, dir, "config/a.properties", "config/b.properties")
+	path := filepath.Join(dir, "config", "a.properties")
+	stagedPath := filepath.Join(dir, "config", "b.properties")
+
+	dl := NewDownloader(1, 1)
+	dl.State = NewInstallState(dir)
+	mustRecord(t, dl.State.Record(path, path, FileEntry{Type: TypeFile}, "a"))
+	tx := NewTransaction(dir)
+	// The new version of b.properties is staged by the same transaction, so the staged version is patched.
+	staged := tx.Stage(stagedPath)
+	if err := ioutil.WriteFile(staged, []byte("new=1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tx.Replace(staged, stagedPath)
+
+	patch := FileEntry{Type: TypePatch, Set: map[string]interface{}{"key": "value"}}
+	dl.addPatch(patch, path, "patch a", false)
+	dl.addPatch(patch, stagedPath, "patch b", false)
+	if errors := dl.ApplyPatches(tx); len(errors) != 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+	if content := readString(t, path); content != "config/a.properties" {
+		t.Errorf("file was patched before committing: %q", content)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("commit failed: %s", err)
+	}
+	tx.Cleanup()
+
+	if content := readString(t, path); content != "config/a.properties\nkey=value\n" {
+		t.Errorf("unexpected patched content: %q", content)
+	}
+	if content := readString(t, stagedPath); content != "new=1\nkey=value\n" {
+		t.Errorf("unexpected patched content of staged file: %q", content)
+	}
+	if problems := (FileEntry{Type: TypeFile}).verifyFile(dl.State, path, path, "a"); len(problems) != 0 {
+		t.Errorf("patched file doesn't match its record: %v", problems)
+	}
+}
+
+func TestApplyPatchesFailure(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	writeFiles(t, dir, "config/a.json")
+	path := filepath.Join(dir, "config", "a.json")
+
+	dl := NewDownloader(1, 1)
+	dl.State = NewInstallState(dir)
+	tx := NewTransaction(dir)
+	dl.addPatch(FileEntry{Type: TypePatch, Set: map[string]interface{}{"key": "value"}}, path, "patch", false)
+	if errors := dl.ApplyPatches(tx); len(errors) != 1 {
+		t.Fatalf("expected patching invalid JSON to fail, got %v", errors)
+	}
+	tx.Cleanup()
+	if content := readString(t, path); content != "config/a.json" {
+		t.Errorf("file was changed by the failed patch: %q", content)
+	}
+	if dl.State.IsPatched(path) {
+		t.Error("failed patch was recorded")
+	}
+}
+
+func TestVerifyPatch(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "options.txt")
+	state := NewInstallState(dir)
+	patch := FileEntry{Type: TypePatch, Set: map[string]interface{}{"fov": float64(90)}}
+	keep := patch
+	keep.Policy = PolicyKeepIfModified
+
+	if problems := patch.Verify(state, path, "patch", SideClient); len(problems) != 1 || problems[0].Type != ProblemMissing {
+		t.Errorf("expected the patched file to be missing, got %v", problems)
+	}
+	if err := ioutil.WriteFile(path, []byte("fov=70\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if problems := patch.Verify(state, path, "patch", SideClient); len(problems) != 1 || problems[0].Type != ProblemChanged {
+		t.Errorf("expected the unapplied patch to be reported, got %v", problems)
+	}
+	if err := patch.applyPatch(state, path, path, "patch"); err != nil {
+		t.Fatalf("failed to apply patch: %s", err)
+	}
+	if problems := patch.Verify(state, path, "patch", SideClient); len(problems) != 0 {
+		t.Errorf("unexpected problems after patching: %v", problems)
+	}
+	if err := ioutil.WriteFile(path, []byte("fov=80\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if problems := patch.Verify(state, path, "patch", SideClient); len(problems) != 1 || problems[0].Type != ProblemChanged {
+		t.Errorf("expected the changed key to be reported, got %v", problems)
+	}
+	if problems := keep.Verify(state, path, "patch", SideClient); len(problems) != 0 {
+		t.Errorf("keys kept by the policy shouldn't be reported, got %v", problems)
+	}
+}
+
+func TestPatchedInlineFileUnchanged(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "options.txt")
+	gp := GoPack{Name: "Test", SimpleName: "test", Version: "1", Files: FileEntry{
+		Type: TypeDirectory,
+		Children: map[string]FileEntry{
+			"options":     {Type: TypeInline, FileName: "options.txt", Version: "1", Content: "fov=70\n"},
+			"options.txt": {Type: TypePatch, Set: map[string]interface{}{"fov": float64(90)}},
+		},
+	}}
+	gp.Install(NewDownloader(1, 1), nil, dir, dir, SideServer)
+	if content := readString(t, path); content != "fov=90\n" {
+		t.Fatalf("unexpected patched content: %q", content)
+	}
+
+	// The inline content hasn't changed, so the update must not rewrite the file and lose the user's changes.
+	if err := ioutil.WriteFile(path, []byte("fov=90\nuser=1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	gp.Update(NewDownloader(1, 1), gp, nil, dir, dir, SideServer)
+	if content := readString(t, path); content != "fov=90\nuser=1\n" {
+		t.Errorf("unchanged inline file was rewritten on update: %q", content)
+	}
+
+	changed := gp
+	changed.Files.Children = map[string]FileEntry{
+		"options":     {Type: TypeInline, FileName: "options.txt", Version: "1", Content: "fov=70\nnew=1\n"},
+		"options.txt": gp.Files.Children["options.txt"],
+	}
+	gp.Update(NewDownloader(1, 1), changed, nil, dir, dir, SideServer)
+	if content := readString(t, path); content != "fov=90\nnew=1\n" {
+		t.Errorf("changed inline file wasn't updated and patched: %q", content)
+	}
+}
@@ -0,0 +1,168 @@
+// goPacked - A simple text-based Minecraft modpack manager.
+// Copyright (C) 2019 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package gopacked
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"maunium.net/go/gopacked/lib/log"
+)
+
+// BackupSuffix is appended to the names of modified files that are backed up before being overwritten.
+const BackupSuffix = ".gopacked-old"
+
+// isModified checks whether the file at path differs from the version recorded in the install state.
+// Files without a record are never considered modified, as there's nothing to compare them to.
+func isModified(state *InstallState, path string) bool {
+	record := state.Get(path)
+	if record == nil {
+		return false
+	}
+	size, hash, err := hashFile(path)
+	return err == nil && (size != record.Size || hash != record.SHA256)
+}
+
+// keepsModified checks whether the update policy of this file entry keeps files that the user has modified.
+func (fe FileEntry) keepsModified() bool {
+	return fe.Policy == PolicyKeepIfModified || fe.Policy == PolicyMerge
+}
+
+// backupModified backs up the files of this file entry at path that have been modified since they were installed.
+// It's called before the entry is reinstalled outside an update (i.e. by repair), where the update policy can't
+// be applied to the files, so that the changes aren't lost.
+func (fe FileEntry) backupModified(state *InstallState, path, name string) {
+	if fe.Policy == PolicyOverwrite || len(fe.Policy) == 0 {
+		return
+	}
+	var files []string
+	if fe.merges() {
+		for _, key := range state.Owned(path, name) {
+			files = append(files, state.Path(key))
+		}
+	} else if fe.Type.IsArchive() {
+		prefix := state.Key(path) + "/"
+		for _, key := range state.Keys() {
+			if strings.HasPrefix(key, prefix) {
+				files = append(files, state.Path(key))
+			}
+		}
+	} else if fe.Type == TypeFile || fe.Type == TypeInline {
+		files = []string{path}
+	}
+	for _, file := range files {
+		if !isModified(state, file) {
+			continue
+		}
+		log.Infof("Backing up modified %[1]s to %[2]s", file, filepath.Base(file)+BackupSuffix)
+		err := copyFile(file, file+BackupSuffix)
+		if err != nil {
+			log.Warnf("Failed to back up %[1]s: %[2]s", file, err)
+		}
+	}
+}
+
+// preserveFile applies the update policy of this file entry to the file at path, which is about to be replaced.
+// Returns false if the file should be kept and the update skipped.
+func (fe FileEntry) preserveFile(state *InstallState, tx *Transaction, path, name string) bool {
+	if fe.Policy == PolicyOverwrite || len(fe.Policy) == 0 || !isModified(state, path) {
+		return true
+	}
+	switch fe.Policy {
+	case PolicyKeepIfModified, PolicyMerge:
+		log.Infof("Keeping %s, as it has been modified", name)
+		return false
+	case PolicyBackupThenOverwrite:
+		log.Infof("Backing up modified %[1]s to %[2]s", name, filepath.Base(path)+BackupSuffix)
+		backupPath := path + BackupSuffix
+		staged := tx.Stage(backupPath)
+		err := copyFile(path, staged)
+		if err != nil {
+			log.Warnf("Failed to back up %[1]s: %[2]s", path, err)
+		} else {
+			tx.Replace(staged, backupPath)
+		}
+	}
+	return true
+}
+
+// preserveArchive applies the update policy of this file entry to the files in the archive directory at target.
+// It's called after the new version has been extracted to the staging directory and copies the files that should be
+// preserved from target to the staging directory. The returned map contains the records of the new versions of
+// the files that were kept, so that they can still be detected as modified in the next update, and nil records for
+// the files added by the user, so that they aren't recorded as installed by goPacked and removed later.
+func (fe FileEntry) preserveArchive(state *InstallState, target, staged, name string) (map[string]*FileState, error) {
+	kept := make(map[string]*FileState)
+	if fe.Policy == PolicyOverwrite || len(fe.Policy) == 0 {
+		return kept, nil
+	}
+	err := filepath.Walk(target, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(target, path)
+		if err != nil {
+			return err
+		}
+		stagedPath := filepath.Join(staged, rel)
+		if strings.HasSuffix(path, BackupSuffix) {
+			// Keep old backups around.
+			return copyFile(path, stagedPath)
+		} else if state.Get(path) == nil {
+			// The file wasn't installed by goPacked, so the user must have added it.
+			if fe.Policy == PolicyMerge {
+				if _, err = os.Stat(stagedPath); os.IsNotExist(err) {
+					kept[state.Key(path)] = nil
+					return copyFile(path, stagedPath)
+				}
+			} else if fe.Policy == PolicyBackupThenOverwrite {
+				log.Infof("Backing up %[1]s to %[2]s", path, filepath.Base(path)+BackupSuffix)
+				return copyFile(path, stagedPath+BackupSuffix)
+			}
+			return nil
+		} else if !isModified(state, path) {
+			return nil
+		}
+
+		switch fe.Policy {
+		case PolicyKeepIfModified, PolicyMerge:
+			if _, err = os.Stat(stagedPath); err == nil {
+				size, hash, err := hashFile(stagedPath)
+				if err != nil {
+					return err
+				}
+				kept[state.Key(path)] = &FileState{Size: size, SHA256: hash, URL: state.Get(path).URL, Version: fe.Version,
+					Entry: name, EntryPath: state.Key(target)}
+			} else {
+				// The new version doesn't have the file, so there's no new version to record. The old record keeps
+				// the file detected as modified, so that it's kept in later updates too.
+				kept[state.Key(path)] = state.Get(path)
+			}
+			log.Infof("Keeping %s, as it has been modified", path)
+			return copyFile(path, stagedPath)
+		case PolicyBackupThenOverwrite:
+			log.Infof("Backing up modified %[1]s to %[2]s", path, filepath.Base(path)+BackupSuffix)
+			return copyFile(path, stagedPath+BackupSuffix)
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		err = nil
+	}
+	return kept, err
+}
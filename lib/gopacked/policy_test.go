@@ -0,0 +1,139 @@
+// goPacked - A simple text-based Minecraft modpack manager.
+// Copyright (C) 2019 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package gopacked
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// zipServer serves zip archives with the given files. The keys are the URL paths of the archives.
+func zipServer(t *testing.T, archives map[string]map[string]string) *httptest.Server {
+	data := make(map[string][]byte)
+	for path, files := range archives {
+		var buf bytes.Buffer
+		writer := zip.NewWriter(&buf)
+		for name, content := range files {
+			w, err := writer.Create(name)
+			if err != nil {
+				t.Fatal(err)
+			} else if _, err = w.Write([]byte(content)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := writer.Close(); err != nil {
+			t.Fatal(err)
+		}
+		data[path] = buf.Bytes()
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if archive, ok := data[r.URL.Path]; ok {
+			_, _ = w.Write(archive)
+		} else {
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+// archivePack creates a goPack that extracts the archive at the given URL to the cfgdir directory.
+func archivePack(version Version, url string, policy UpdatePolicy) GoPack {
+	return GoPack{Name: "Test", SimpleName: "test", Version: version, Files: FileEntry{
+		Type: TypeDirectory,
+		Children: map[string]FileEntry{
+			"cfgdir": {Type: TypeZipArchive, Version: version, URL: url, Policy: policy},
+		},
+	}}
+}
+
+func TestKeptFileVerifyAndRepair(t *testing.T) {
+	server := zipServer(t, map[string]map[string]string{
+		"/v1.zip": {"a.cfg": "a=1", "b.cfg": "b=1"},
+		"/v2.zip": {"a.cfg": "a=1new", "b.cfg": "b=2"},
+	})
+	defer server.Close()
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	cfgA := filepath.Join(dir, "cfgdir", "a.cfg")
+	cfgB := filepath.Join(dir, "cfgdir", "b.cfg")
+
+	v1 := archivePack("1", server.URL+"/v1.zip", PolicyKeepIfModified)
+	v2 := archivePack("2", server.URL+"/v2.zip", PolicyKeepIfModified)
+	v1.Install(NewDownloader(1, 1), nil, dir, dir, SideServer)
+	if err := ioutil.WriteFile(cfgA, []byte("a=USER"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	v1.Update(NewDownloader(1, 1), v2, nil, dir, dir, SideServer)
+	if content := readString(t, cfgA); content != "a=USER" {
+		t.Fatalf("modified file wasn't kept on update: %q", content)
+	} else if content = readString(t, cfgB); content != "b=2" {
+		t.Fatalf("unmodified file wasn't updated: %q", content)
+	}
+
+	if problems := v2.Verify(dir, dir, SideServer); len(problems) != 0 {
+		t.Errorf("kept file was reported as a problem: %v", problems)
+	}
+
+	// Repair has to reinstall the archive because of the missing file, which must not lose the user's changes.
+	if err := os.Remove(cfgB); err != nil {
+		t.Fatal(err)
+	}
+	if problems := v2.Verify(dir, dir, SideServer); len(problems) != 1 || problems[0].Path != cfgB {
+		t.Fatalf("expected only the missing file to be reported, got %v", problems)
+	}
+	v2.Repair(NewDownloader(1, 1), dir, dir, SideServer)
+	if content := readString(t, cfgB); content != "b=2" {
+		t.Errorf("missing file wasn't repaired: %q", content)
+	}
+	if content, err := ioutil.ReadFile(cfgA + BackupSuffix); err != nil || string(content) != "a=USER" {
+		t.Errorf("modified file wasn't backed up before repairing: %q %v", content, err)
+	}
+}
+
+func TestPreserveArchiveRemovedFile(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	target := filepath.Join(dir, "cfgdir")
+	staged := filepath.Join(dir, "staged")
+	writeFiles(t, dir, "cfgdir/a.cfg", "cfgdir/b.cfg", "staged/b.cfg")
+
+	entry := FileEntry{Type: TypeZipArchive, Version: "2", Policy: PolicyKeepIfModified}
+	state := NewInstallState(dir)
+	mustRecord(t, state.RecordTree(target, target, entry, "cfgdir"))
+	old := state.Get(filepath.Join(target, "a.cfg"))
+	if err := ioutil.WriteFile(filepath.Join(target, "a.cfg"), []byte("a=USER"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The new version of the archive doesn't have a.cfg anymore.
+	kept, err := entry.preserveArchive(state, target, staged, "cfgdir")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if content := readString(t, filepath.Join(staged, "a.cfg")); !strings.HasPrefix(content, "a=USER") {
+		t.Errorf("modified file wasn't kept: %q", content)
+	}
+	if record, ok := kept["cfgdir/a.cfg"]; !ok || record != old {
+		t.Errorf("expected the old record to be kept, got %+v", record)
+	}
+}
@@ -0,0 +1,341 @@
+// goPacked - A simple text-based Minecraft modpack manager.
+// Copyright (C) 2019 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package gopacked
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// DefinitionSchema is the JSON Schema of goPack definitions in the current format. It's published as
+// gopack.schema.json in the root of the repository, which can be regenerated with `make schema`.
+const DefinitionSchema = `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "title": "goPack definition",
+  "type": "object",
+  "required": ["name", "simplename", "update-url", "author", "version", "files"],
+  "additionalProperties": false,
+  "properties": {
+    "$schema": {"type": "string"},
+    "format-version": {"type": "integer", "minimum": 1, "description": "The version of the definition format."},
+    "name": {"type": "string"},
+    "simplename": {"type": "string", "pattern": "^[^/\\\\]+$", "description": "The name of the install directory."},
+    "update-url": {"type": "string"},
+    "author": {"type": "string"},
+    "version": {"$ref": "#/definitions/version"},
+    "minecraft-version": {"type": "string"},
+    "forge-version": {"type": "string"},
+    "gopacked-version": {"type": "string", "description": "A version constraint like >=0.4 <0.6."},
+    "signing-key": {"type": "string"},
+    "extends": {"type": "string", "description": "The URL of the goPack definition this definition is based on."},
+    "parent": {
+      "type": "object",
+      "additionalProperties": false,
+      "properties": {
+        "name": {"type": "string"},
+        "url": {"type": "string"},
+        "version": {"$ref": "#/definitions/version"}
+      }
+    },
+    "variables": {
+      "type": "object",
+      "additionalProperties": {"type": "string"},
+      "description": "Template variables that can be used as ${name} in the url, mirrors, filename and content fields."
+    },
+    "profile-settings": {"type": ["object", "null"]},
+    "mcl-version": {"$ref": "#/definitions/fileEntry"},
+    "files": {"$ref": "#/definitions/fileEntry"}
+  },
+  "definitions": {
+    "version": {
+      "type": "string",
+      "pattern": "^([0-9]+(\\.[0-9]+)*(-[0-9A-Za-z-]+(\\.[0-9A-Za-z-]+)*)?(\\+[0-9A-Za-z-]+(\\.[0-9A-Za-z-]+)*)?)?$"
+    },
+    "stringList": {
+      "type": "array",
+      "items": {"type": "string"}
+    },
+    "fileEntry": {
+      "type": "object",
+      "required": ["type"],
+      "additionalProperties": false,
+      "properties": {
+        "type": {
+          "enum": ["directory", "file", "zip-archive", "tar-gz-archive", "tar-bz2-archive", "inline", "patch"]
+        },
+        "filename": {"type": "string"},
+        "version": {"$ref": "#/definitions/version"},
+        "side": {"enum": ["client", "server", "both"]},
+        "url": {"type": "string"},
+        "mirrors": {"$ref": "#/definitions/stringList"},
+        "sha1": {"type": "string", "pattern": "^[0-9a-fA-F]{40}$"},
+        "sha256": {"type": "string", "pattern": "^[0-9a-fA-F]{64}$"},
+        "sha512": {"type": "string", "pattern": "^[0-9a-fA-F]{128}$"},
+        "update-policy": {"enum": ["overwrite", "keep-if-modified", "merge", "backup-then-overwrite"]},
+        "children": {
+          "type": "object",
+          "additionalProperties": {"$ref": "#/definitions/fileEntry"}
+        },
+        "optional": {"type": "boolean"},
+        "default": {"type": "boolean"},
+        "description": {"type": "string"},
+        "conditions": {"$ref": "#/definitions/conditions"},
+        "content": {"type": "string"},
+        "encoding": {"enum": ["utf-8", "base64"]},
+        "format": {"enum": ["json", "toml", "properties", "forge-cfg"]},
+        "set": {"type": "object"},
+        "unset": {"$ref": "#/definitions/stringList"},
+        "extract-mode": {"enum": ["replace", "merge"]},
+        "strip-components": {"type": "integer", "minimum": 0},
+        "archive-path": {"type": "string"},
+        "include": {"$ref": "#/definitions/stringList"},
+        "exclude": {"$ref": "#/definitions/stringList"}
+      }
+    },
+    "conditions": {
+      "type": "object",
+      "additionalProperties": false,
+      "properties": {
+        "os": {"$ref": "#/definitions/stringList"},
+        "arch": {"$ref": "#/definitions/stringList"},
+        "java-version-minimum": {"type": "integer", "minimum": 1},
+        "memory-minimum": {"type": "integer", "minimum": 0}
+      }
+    }
+  }
+}
+`
+
+// schemaValidator validates JSON values against a JSON Schema. Only the keywords used by DefinitionSchema are
+// supported: $ref (to definitions in the same schema), type, enum, pattern, minimum, properties, required,
+// additionalProperties and items.
+type schemaValidator struct {
+	root     map[string]interface{}
+	patterns map[string]*regexp.Regexp
+}
+
+func newSchemaValidator(schema string) (*schemaValidator, error) {
+	var root map[string]interface{}
+	err := json.Unmarshal([]byte(schema), &root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse schema: %s", err)
+	}
+	return &schemaValidator{root: root, patterns: make(map[string]*regexp.Regexp)}, nil
+}
+
+// validate validates the given value against the given schema and returns the problems found.
+// The value must be decoded with json.Decoder.UseNumber.
+func (sv *schemaValidator) validate(schema map[string]interface{}, value interface{}, path string) []LintProblem {
+	if ref, ok := schema["$ref"].(string); ok {
+		resolved, err := sv.resolve(ref)
+		if err != nil {
+			return []LintProblem{{path, err.Error()}}
+		}
+		return sv.validate(resolved, value, path)
+	}
+
+	if types, ok := schema["type"]; ok && !matchesType(types, value) {
+		return []LintProblem{{path, fmt.Sprintf("expected %s, got %s", describeTypes(types), jsonType(value))}}
+	}
+	if enum, ok := schema["enum"].([]interface{}); ok && !inEnum(enum, value) {
+		return []LintProblem{{path, fmt.Sprintf("%s is not one of %s", describeValue(value), describeEnum(enum))}}
+	}
+
+	var problems []LintProblem
+	switch typedValue := value.(type) {
+	case string:
+		if pattern, ok := schema["pattern"].(string); ok {
+			regex, err := sv.pattern(pattern)
+			if err != nil {
+				problems = append(problems, LintProblem{path, err.Error()})
+			} else if !regex.MatchString(typedValue) {
+				problems = append(problems, LintProblem{path, fmt.Sprintf("%q is not valid", typedValue)})
+			}
+		}
+	case json.Number:
+		if minimum, ok := schema["minimum"].(float64); ok {
+			if number, err := typedValue.Float64(); err == nil && number < minimum {
+				problems = append(problems, LintProblem{path, fmt.Sprintf("%s is smaller than %v", typedValue, minimum)})
+			}
+		}
+	case []interface{}:
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, item := range typedValue {
+				problems = append(problems, sv.validate(items, item, fmt.Sprintf("%s[%d]", path, i))...)
+			}
+		}
+	case map[string]interface{}:
+		problems = append(problems, sv.validateObject(schema, typedValue, path)...)
+	}
+	return problems
+}
+
+func (sv *schemaValidator) validateObject(schema, object map[string]interface{}, path string) (problems []LintProblem) {
+	if required, ok := schema["required"].([]interface{}); ok {
+		for _, field := range required {
+			if _, ok := object[field.(string)]; !ok {
+				problems = append(problems, LintProblem{path, fmt.Sprintf("missing required field %s", field)})
+			}
+		}
+	}
+	properties, _ := schema["properties"].(map[string]interface{})
+	for _, key := range sortedKeys(object) {
+		keyPath := jsonPath(path, key)
+		if property, ok := properties[key].(map[string]interface{}); ok {
+			problems = append(problems, sv.validate(property, object[key], keyPath)...)
+			continue
+		}
+		switch additional := schema["additionalProperties"].(type) {
+		case bool:
+			if !additional {
+				problems = append(problems, LintProblem{keyPath, fmt.Sprintf("unknown field %s", key)})
+			}
+		case map[string]interface{}:
+			problems = append(problems, sv.validate(additional, object[key], keyPath)...)
+		}
+	}
+	return
+}
+
+func (sv *schemaValidator) resolve(ref string) (map[string]interface{}, error) {
+	if !strings.HasPrefix(ref, "#/") {
+		return nil, fmt.Errorf("unsupported schema reference %s", ref)
+	}
+	var current interface{} = sv.root
+	for _, part := range strings.Split(ref[2:], "/") {
+		object, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("invalid schema reference %s", ref)
+		}
+		current = object[part]
+	}
+	resolved, ok := current.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid schema reference %s", ref)
+	}
+	return resolved, nil
+}
+
+func (sv *schemaValidator) pattern(pattern string) (*regexp.Regexp, error) {
+	regex, ok := sv.patterns[pattern]
+	if !ok {
+		var err error
+		regex, err = regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schema pattern %s: %s", pattern, err)
+		}
+		sv.patterns[pattern] = regex
+	}
+	return regex, nil
+}
+
+// jsonType returns the JSON Schema type name of the given decoded JSON value.
+func jsonType(value interface{}) string {
+	switch typedValue := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case json.Number:
+		if _, err := typedValue.Int64(); err == nil {
+			return "integer"
+		}
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
+
+func matchesType(types, value interface{}) bool {
+	actual := jsonType(value)
+	check := func(expected interface{}) bool {
+		return expected == actual || (expected == "number" && actual == "integer")
+	}
+	if list, ok := types.([]interface{}); ok {
+		for _, expected := range list {
+			if check(expected) {
+				return true
+			}
+		}
+		return false
+	}
+	return check(types)
+}
+
+func describeTypes(types interface{}) string {
+	if list, ok := types.([]interface{}); ok {
+		names := make([]string, len(list))
+		for i, name := range list {
+			names[i] = fmt.Sprint(name)
+		}
+		return strings.Join(names, " or ")
+	}
+	return fmt.Sprint(types)
+}
+
+func inEnum(enum []interface{}, value interface{}) bool {
+	for _, allowed := range enum {
+		if allowed == value {
+			return true
+		}
+	}
+	return false
+}
+
+func describeValue(value interface{}) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
+
+func describeEnum(enum []interface{}) string {
+	values := make([]string, len(enum))
+	for i, value := range enum {
+		values[i] = describeValue(value)
+	}
+	return strings.Join(values, ", ")
+}
+
+func sortedKeys(object map[string]interface{}) []string {
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+var plainKeyRegex = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$-]*$`)
+
+// jsonPath returns the JSON path of the given key in the object at the given path.
+func jsonPath(path, key string) string {
+	if plainKeyRegex.MatchString(key) {
+		return path + "." + key
+	}
+	return path + "[" + describeValue(key) + "]"
+}
@@ -0,0 +1,433 @@
+// goPacked - A simple text-based Minecraft modpack manager.
+// Copyright (C) 2019 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package gopacked
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// StateFileName is the name of the install state file saved next to gopacked.json.
+const StateFileName = "gopacked-state.json"
+
+// InstallState records the files goPacked has actually written to an installation, as opposed to gopacked.json which
+// only contains the definition the installation was supposed to match.
+type InstallState struct {
+	// Files contains the state of each written file. The keys are slash-separated paths relative to the install path.
+	Files map[string]*FileState `json:"files"`
+	// Failed contains the errors of files that failed to install, using the same keys as Files.
+	Failed map[string]string `json:"failed,omitempty"`
+	// Patches contains the config keys changed by patch entries. The keys are the same as in Files,
+	// and the inner keys are the config keys.
+	Patches map[string]map[string]*PatchedKey `json:"patches,omitempty"`
+
+	// Legacy is true if the installation didn't have a state file, i.e. it was installed by an old goPacked version.
+	Legacy bool `json:"-"`
+
+	root string
+	lock sync.Mutex
+}
+
+// FileState contains the info of a single file written by goPacked.
+type FileState struct {
+	Size    int64   `json:"size"`
+	SHA256  string  `json:"sha256"`
+	URL     string  `json:"url,omitempty"`
+	Version Version `json:"version,omitempty"`
+	// Entry is the display name of the file entry the file came from.
+	Entry string `json:"entry,omitempty"`
+	// EntryPath is the key of the path the file entry is installed to, e.g. the directory a merge-mode archive is
+	// extracted to. Display names are only unique within a directory, so the path is needed to identify the entry.
+	EntryPath string `json:"entry-path,omitempty"`
+	// UnpatchedSHA256 is the hash the file had before it was changed by patch entries, i.e. the hash of the content
+	// of its entry. Empty if the file hasn't been patched.
+	UnpatchedSHA256 string `json:"unpatched-sha256,omitempty"`
+}
+
+// PatchedKey records a config key changed by a patch entry, so that the change can be reverted.
+type PatchedKey struct {
+	// Entry is the display name of the patch entry that changed the key.
+	Entry string `json:"entry"`
+	// Original is the value the key had before it was patched. Existed is false if the key didn't exist.
+	Original interface{} `json:"original"`
+	Existed  bool        `json:"existed"`
+	// Value is the value the patch set, or nil if the patch removed the key.
+	Value interface{} `json:"value"`
+}
+
+// NewInstallState creates an empty install state for the installation at the given path.
+func NewInstallState(root string) *InstallState {
+	return &InstallState{
+		Files:   make(map[string]*FileState),
+		Failed:  make(map[string]string),
+		Patches: make(map[string]map[string]*PatchedKey),
+		root:    root,
+	}
+}
+
+// LoadInstallState loads the install state of the installation at the given path. If the installation has no state
+// file, an empty state with Legacy set to true is returned.
+func LoadInstallState(root string) (*InstallState, error) {
+	state := NewInstallState(root)
+	data, err := ioutil.ReadFile(filepath.Join(root, StateFileName))
+	if os.IsNotExist(err) {
+		state.Legacy = true
+		return state, nil
+	} else if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, state)
+	if err != nil {
+		return nil, err
+	}
+	if state.Files == nil {
+		state.Files = make(map[string]*FileState)
+	}
+	if state.Failed == nil {
+		state.Failed = make(map[string]string)
+	}
+	if state.Patches == nil {
+		state.Patches = make(map[string]map[string]*PatchedKey)
+	}
+	return state, nil
+}
+
+// Save saves the install state to the given file.
+func (state *InstallState) Save(path string) error {
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// Key converts the given path into an install state key.
+func (state *InstallState) Key(path string) string {
+	rel, err := filepath.Rel(state.root, path)
+	if err != nil {
+		return filepath.ToSlash(path)
+	}
+	return filepath.ToSlash(rel)
+}
+
+// Path converts the given install state key into a path.
+func (state *InstallState) Path(key string) string {
+	path := filepath.FromSlash(key)
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(state.root, path)
+}
+
+// Record hashes the file at the given path and records it as the file at target.
+func (state *InstallState) Record(path, target string, entry FileEntry, name string) error {
+	if state == nil {
+		return nil
+	}
+	return state.record(path, target, target, entry, name)
+}
+
+// record hashes the file at the given path and records it as the file at target, which belongs to the file entry
+// installed to entryPath.
+func (state *InstallState) record(path, target, entryPath string, entry FileEntry, name string) error {
+	size, hash, err := hashFile(path)
+	if err != nil {
+		return err
+	}
+	var url string
+	if urls := entry.URLs(); len(urls) != 0 {
+		url = urls[0]
+	}
+	key := state.Key(target)
+	state.lock.Lock()
+	state.Files[key] = &FileState{
+		Size:      size,
+		SHA256:    hash,
+		URL:       url,
+		Version:   entry.Version,
+		Entry:     name,
+		EntryPath: state.Key(entryPath),
+	}
+	delete(state.Failed, key)
+	state.lock.Unlock()
+	return nil
+}
+
+// RecordTree records all the files in the directory at path as the files in the directory at target.
+func (state *InstallState) RecordTree(path, target string, entry FileEntry, name string) error {
+	if state == nil {
+		return nil
+	}
+	return filepath.Walk(path, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || strings.HasSuffix(filePath, BackupSuffix) {
+			return err
+		}
+		rel, err := filepath.Rel(path, filePath)
+		if err != nil {
+			return err
+		}
+		return state.record(filePath, filepath.Join(target, rel), target, entry, name)
+	})
+}
+
+// RecordFiles records the given files in the directory at path as the files in the directory at target.
+// The file names are slash-separated and relative to the directory.
+func (state *InstallState) RecordFiles(path, target string, files []string, entry FileEntry, name string) error {
+	if state == nil {
+		return nil
+	}
+	for _, file := range files {
+		file = filepath.FromSlash(file)
+		err := state.record(filepath.Join(path, file), filepath.Join(target, file), target, entry, name)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Copy returns a copy of the install state that isn't affected by changes to the original.
+func (state *InstallState) Copy() *InstallState {
+	if state == nil {
+		return nil
+	}
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	copied := NewInstallState(state.root)
+	copied.Legacy = state.Legacy
+	for key, record := range state.Files {
+		copied.Files[key] = record
+	}
+	for key, err := range state.Failed {
+		copied.Failed[key] = err
+	}
+	for key, patched := range state.Patches {
+		copied.Patches[key] = patched
+	}
+	return copied
+}
+
+// Set sets the records of the given keys directly. Keys with a nil record are removed.
+func (state *InstallState) Set(records map[string]*FileState) {
+	if state == nil {
+		return
+	}
+	state.lock.Lock()
+	for key, record := range records {
+		if record == nil {
+			delete(state.Files, key)
+		} else {
+			state.Files[key] = record
+		}
+	}
+	state.lock.Unlock()
+}
+
+// RecordFailure records that installing the file or directory at target failed.
+func (state *InstallState) RecordFailure(target string, err error) {
+	if state == nil {
+		return
+	}
+	state.lock.Lock()
+	state.Failed[state.Key(target)] = err.Error()
+	state.lock.Unlock()
+}
+
+// Forget removes the records of the file at target, or all the files inside target if it's a directory.
+func (state *InstallState) Forget(target string) {
+	if state == nil {
+		return
+	}
+	key := state.Key(target)
+	state.lock.Lock()
+	for path := range state.Files {
+		if path == key || strings.HasPrefix(path, key+"/") {
+			delete(state.Files, path)
+		}
+	}
+	for path := range state.Failed {
+		if path == key || strings.HasPrefix(path, key+"/") {
+			delete(state.Failed, path)
+		}
+	}
+	state.lock.Unlock()
+}
+
+// Has checks whether the file at target, or any file inside target if it's a directory, has been installed.
+// For legacy installations without a state file, the file system is checked instead.
+func (state *InstallState) Has(target string) bool {
+	if state == nil || state.Legacy {
+		_, err := os.Stat(target)
+		return err == nil
+	}
+	key := state.Key(target)
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	if _, ok := state.Files[key]; ok {
+		return true
+	}
+	for path := range state.Files {
+		if strings.HasPrefix(path, key+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Owned returns the keys of the recorded files inside the directory at target that came from the file entry with
+// the given name installed to target. Several merge-mode archives can be extracted to the same directory, so both
+// the path and the name of the entry must match.
+func (state *InstallState) Owned(target, name string) []string {
+	if state == nil {
+		return nil
+	}
+	entryPath := state.Key(target)
+	prefix := entryPath + "/"
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	var keys []string
+	for key, record := range state.Files {
+		if record.EntryPath == entryPath && record.Entry == name && strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// PatchedKeys returns the config keys in the file at target that were changed by the patch entry with the given name.
+func (state *InstallState) PatchedKeys(target, name string) map[string]*PatchedKey {
+	keys := make(map[string]*PatchedKey)
+	if state == nil {
+		return keys
+	}
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	for key, patched := range state.Patches[state.Key(target)] {
+		if patched.Entry == name {
+			keys[key] = patched
+		}
+	}
+	return keys
+}
+
+// SetPatchedKeys replaces the records of the config keys in the file at target changed by the patch entry with
+// the given name.
+func (state *InstallState) SetPatchedKeys(target, name string, keys map[string]*PatchedKey) {
+	if state == nil {
+		return
+	}
+	fileKey := state.Key(target)
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	patched := make(map[string]*PatchedKey)
+	for key, record := range state.Patches[fileKey] {
+		if record.Entry != name {
+			patched[key] = record
+		}
+	}
+	for key, record := range keys {
+		patched[key] = record
+	}
+	if len(patched) == 0 {
+		delete(state.Patches, fileKey)
+	} else {
+		state.Patches[fileKey] = patched
+	}
+}
+
+// IsPatched checks whether any config keys in the file at target have been changed by patch entries.
+func (state *InstallState) IsPatched(target string) bool {
+	if state == nil {
+		return false
+	}
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	return len(state.Patches[state.Key(target)]) > 0
+}
+
+// Rehash updates the recorded size and hash of the file at target to match the file at path, so that changes made
+// by goPacked itself (i.e. patches) aren't detected as modifications. The hash from before the first patch is kept,
+// as it's still the hash of the content of the file entry. Files without a record are ignored.
+func (state *InstallState) Rehash(path, target string) error {
+	if state.Get(target) == nil {
+		return nil
+	}
+	size, hash, err := hashFile(path)
+	if err != nil {
+		return err
+	}
+	key := state.Key(target)
+	state.lock.Lock()
+	// The records may be shared with copies of the state, so they're replaced instead of modified.
+	if current := state.Files[key]; current != nil {
+		record := *current
+		if len(record.UnpatchedSHA256) == 0 {
+			record.UnpatchedSHA256 = record.SHA256
+		}
+		record.Size, record.SHA256 = size, hash
+		state.Files[key] = &record
+	}
+	state.lock.Unlock()
+	return nil
+}
+
+// Get returns the recorded state of the file at target, or nil if there is no record.
+func (state *InstallState) Get(target string) *FileState {
+	if state == nil {
+		return nil
+	}
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	return state.Files[state.Key(target)]
+}
+
+// Keys returns the keys of all recorded files in alphabetical order.
+func (state *InstallState) Keys() []string {
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	keys := make([]string, 0, len(state.Files))
+	for key := range state.Files {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func hashFile(path string) (int64, string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return 0, "", err
+	}
+	return size, hex.EncodeToString(hash.Sum(nil)), nil
+}
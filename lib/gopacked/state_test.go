@@ -0,0 +1,76 @@
+// goPacked - A simple text-based Minecraft modpack manager.
+// Copyright (C) 2019 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package gopacked
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeFiles(t *testing.T, dir string, files ...string) {
+	for _, file := range files {
+		path := filepath.Join(dir, filepath.FromSlash(file))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(file), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestInstallStateOwned(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	writeFiles(t, dir, "config/a.cfg", "config/b.cfg", "config/sub/c.cfg", "other/config/a.cfg")
+
+	state := NewInstallState(dir)
+	entry := FileEntry{Type: TypeZipArchive, ExtractMode: ExtractMerge}
+	config := filepath.Join(dir, "config")
+	other := filepath.Join(dir, "other", "config")
+	// Two archives with different names share the config directory, and an archive in another directory has the
+	// same name as one of them.
+	mustRecord(t, state.RecordFiles(config, config, []string{"a.cfg", "sub/c.cfg"}, entry, "Configs"))
+	mustRecord(t, state.RecordFiles(config, config, []string{"b.cfg"}, entry, "More configs"))
+	mustRecord(t, state.RecordFiles(other, other, []string{"a.cfg"}, entry, "Configs"))
+
+	tests := []struct {
+		target   string
+		name     string
+		expected []string
+	}{
+		{config, "Configs", []string{"config/a.cfg", "config/sub/c.cfg"}},
+		{config, "More configs", []string{"config/b.cfg"}},
+		{other, "Configs", []string{"other/config/a.cfg"}},
+		{filepath.Join(config, "sub"), "Configs", nil},
+		{config, "Renamed", nil},
+	}
+	for _, test := range tests {
+		if owned := state.Owned(test.target, test.name); !reflect.DeepEqual(owned, test.expected) {
+			t.Errorf("Owned(%s, %s): expected %v, got %v", state.Key(test.target), test.name, test.expected, owned)
+		}
+	}
+}
+
+func mustRecord(t *testing.T, err error) {
+	if err != nil {
+		t.Fatalf("failed to record files: %s", err)
+	}
+}
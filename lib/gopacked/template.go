@@ -0,0 +1,184 @@
+// goPacked - A simple text-based Minecraft modpack manager.
+// Copyright (C) 2019 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package gopacked
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// builtinVariables are the names of the template variables goPacked defines.
+var builtinVariables = []string{"minecraft-version", "pack-version", "simplename", "side"}
+
+// MinecraftVersion returns the Minecraft version of the goPack. If the minecraft-version field isn't set,
+// the version is taken from the forge-version field (e.g. 1.12.2 from 1.12.2-14.23.5.2847).
+func (gp GoPack) MinecraftVersion() string {
+	if len(gp.MCVersion) != 0 {
+		return gp.MCVersion
+	} else if index := strings.IndexRune(gp.ForgeVer, '-'); index > 0 {
+		return gp.ForgeVer[:index]
+	}
+	return ""
+}
+
+// TemplateVariables returns the variables that can be used in file entries when installing the given side.
+// The values of the variables defined in the goPack may refer to the built-in variables, which can't be overridden.
+func (gp GoPack) TemplateVariables(side Side) (map[string]string, error) {
+	builtin := map[string]string{
+		"pack-version": gp.Version.String(),
+		"simplename":   gp.SimpleName,
+		"side":         string(side),
+	}
+	if minecraftVersion := gp.MinecraftVersion(); len(minecraftVersion) != 0 {
+		builtin["minecraft-version"] = minecraftVersion
+	}
+	vars := make(map[string]string, len(gp.Variables)+len(builtin))
+	for name, value := range gp.Variables {
+		expanded, err := expandVariables(value, builtin)
+		if err != nil {
+			return nil, fmt.Errorf("variable %s: %s", name, err)
+		}
+		vars[name] = expanded
+	}
+	for name, value := range builtin {
+		vars[name] = value
+	}
+	return vars, nil
+}
+
+// Expand returns a copy of the goPack where the template variables for the given side have been replaced in the
+// mcl-version and files entries. URLs that are still relative after replacing the variables are resolved against
+// the Source of the goPack, like the other relative URLs in the definition.
+func (gp GoPack) Expand(side Side) (GoPack, error) {
+	vars, err := gp.TemplateVariables(side)
+	if err != nil {
+		return gp, err
+	}
+	base, err := url.Parse(gp.Source)
+	if err != nil || !base.IsAbs() {
+		base = nil
+	}
+	expanded := gp
+	expanded.MCLVersion, err = gp.MCLVersion.expand(vars, base)
+	if err != nil {
+		return gp, fmt.Errorf("mcl-version: %s", err)
+	}
+	expanded.Files, err = gp.Files.expand(vars, base)
+	if err != nil {
+		return gp, fmt.Errorf("files: %s", err)
+	}
+	return expanded, nil
+}
+
+// expand returns a copy of the file entry and its children with the template variables replaced.
+func (fe FileEntry) expand(vars map[string]string, base *url.URL) (FileEntry, error) {
+	fe, err := fe.expandFields(vars, base)
+	if err != nil || len(fe.Children) == 0 {
+		return fe, err
+	}
+	children := make(map[string]FileEntry, len(fe.Children))
+	for name, child := range fe.Children {
+		children[name], err = child.expand(vars, base)
+		if err != nil {
+			return fe, fmt.Errorf("%s: %s", name, err)
+		}
+	}
+	fe.Children = children
+	return fe, nil
+}
+
+// expandFields replaces the template variables in the url, mirrors, filename and content fields of the file entry.
+// Base64-encoded content isn't changed.
+func (fe FileEntry) expandFields(vars map[string]string, base *url.URL) (FileEntry, error) {
+	var err error
+	fe.URL, err = expandURL(fe.URL, vars, base)
+	if err != nil {
+		return fe, fmt.Errorf("url: %s", err)
+	}
+	if len(fe.Mirrors) != 0 {
+		mirrors := make([]string, len(fe.Mirrors))
+		for i, mirror := range fe.Mirrors {
+			mirrors[i], err = expandURL(mirror, vars, base)
+			if err != nil {
+				return fe, fmt.Errorf("mirrors: %s", err)
+			}
+		}
+		fe.Mirrors = mirrors
+	}
+	fe.FileName, err = expandVariables(fe.FileName, vars)
+	if err != nil {
+		return fe, fmt.Errorf("filename: %s", err)
+	}
+	if fe.Type == TypeInline && fe.Encoding != EncodingBase64 {
+		fe.Content, err = expandVariables(fe.Content, vars)
+		if err != nil {
+			return fe, fmt.Errorf("content: %s", err)
+		}
+	}
+	return fe, nil
+}
+
+func expandURL(rawURL string, vars map[string]string, base *url.URL) (string, error) {
+	if !strings.Contains(rawURL, "${") {
+		// URLs without variables were already resolved when the definition was loaded.
+		return rawURL, nil
+	}
+	expanded, err := expandVariables(rawURL, vars)
+	if err != nil || base == nil {
+		return expanded, err
+	}
+	ref, err := url.Parse(expanded)
+	if err != nil {
+		return "", fmt.Errorf("invalid URL %s: %s", expanded, err)
+	}
+	return base.ResolveReference(ref).String(), nil
+}
+
+// expandVariables replaces the ${name} references in the given string with the values of the variables.
+// $${ can be used to write a literal ${.
+func expandVariables(str string, vars map[string]string) (string, error) {
+	if !strings.Contains(str, "${") {
+		return str, nil
+	}
+	var buf strings.Builder
+	for {
+		index := strings.Index(str, "${")
+		if index < 0 {
+			buf.WriteString(str)
+			break
+		} else if index > 0 && str[index-1] == '$' {
+			buf.WriteString(str[:index])
+			buf.WriteRune('{')
+			str = str[index+2:]
+			continue
+		}
+		end := strings.IndexRune(str[index:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated variable reference %s", str[index:])
+		}
+		name := str[index+2 : index+end]
+		value, ok := vars[name]
+		if !ok {
+			return "", fmt.Errorf("undefined variable %s", name)
+		}
+		buf.WriteString(str[:index])
+		buf.WriteString(value)
+		str = str[index+end+1:]
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,258 @@
+// goPacked - A simple text-based Minecraft modpack manager.
+// Copyright (C) 2019 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package gopacked
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"maunium.net/go/gopacked/lib/log"
+)
+
+const stagingSuffix = ".gopacked-staging"
+const backupSuffix = ".gopacked-backup"
+
+// Transaction stages the changes of an update in sibling directories of the directories being updated. Once
+// everything has been downloaded and verified, the staged files are moved into place with renames. The files that
+// were replaced or removed are moved into backup directories, so that the changes can be rolled back if any step fails.
+type Transaction struct {
+	roots   []string
+	ops     []*txOp
+	applied []*txOp
+	// lock protects roots and ops, as files can be staged by the download workers.
+	lock sync.Mutex
+}
+
+type txOp struct {
+	// staged is the path of the new file in the staging directory. Empty if the target is just removed.
+	staged string
+	// target is the path the staged file is moved to.
+	target string
+	// backup is the path the previous file at target was moved to. Empty if there was no previous file.
+	backup string
+	// mkdir is true if the target is a directory that is created if it doesn't exist yet, rather than replaced.
+	mkdir bool
+	// created contains the directories that were created for a mkdir operation, deepest first.
+	created []string
+}
+
+// NewTransaction creates a new transaction that stages changes to files inside the given directories.
+// Staging directories left over from previous interrupted transactions are removed.
+func NewTransaction(roots ...string) *Transaction {
+	tx := &Transaction{}
+	for _, root := range roots {
+		tx.roots = append(tx.roots, filepath.Clean(root))
+	}
+	tx.removeDirs(stagingSuffix)
+	return tx
+}
+
+// root finds the root directory that contains the given path. If there is no such root, the parent directory of the
+// path is added as a new root.
+func (tx *Transaction) root(path string) (root, rel string) {
+	path = filepath.Clean(path)
+	for _, root = range tx.roots {
+		if path == root {
+			return root, "."
+		}
+		var err error
+		rel, err = filepath.Rel(root, path)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return root, rel
+		}
+	}
+	root = filepath.Dir(path)
+	tx.roots = append(tx.roots, root)
+	return root, filepath.Base(path)
+}
+
+// Stage returns the path in the staging directory where the new version of the given path should be written.
+func (tx *Transaction) Stage(target string) string {
+	tx.lock.Lock()
+	defer tx.lock.Unlock()
+	root, rel := tx.root(target)
+	staged := filepath.Join(root+stagingSuffix, rel)
+	err := os.MkdirAll(filepath.Dir(staged), 0755)
+	if err != nil {
+		log.Warnf("Failed to create staging directory for %[1]s: %[2]s", target, err)
+	}
+	return staged
+}
+
+// Replace schedules the staged file or directory to be moved to target when the transaction is committed.
+func (tx *Transaction) Replace(staged, target string) {
+	tx.lock.Lock()
+	defer tx.lock.Unlock()
+	tx.ops = append(tx.ops, &txOp{staged: staged, target: target})
+}
+
+// Remove schedules the file or directory at target to be removed when the transaction is committed.
+func (tx *Transaction) Remove(target string) {
+	tx.lock.Lock()
+	defer tx.lock.Unlock()
+	tx.ops = append(tx.ops, &txOp{target: target})
+}
+
+// Mkdir schedules the directory at target to be created when the transaction is committed, unless it already exists.
+func (tx *Transaction) Mkdir(target string) {
+	tx.lock.Lock()
+	defer tx.lock.Unlock()
+	tx.ops = append(tx.ops, &txOp{target: target, mkdir: true})
+}
+
+// Staged returns the path of the staged new version of target if the transaction replaces target or a directory
+// containing it. The returned path is empty if target is removed, and ok is false if the transaction doesn't change it.
+func (tx *Transaction) Staged(target string) (staged string, ok bool) {
+	tx.lock.Lock()
+	defer tx.lock.Unlock()
+	target = filepath.Clean(target)
+	for i := len(tx.ops) - 1; i >= 0; i-- {
+		op := tx.ops[i]
+		if op.mkdir {
+			continue
+		}
+		rel, err := filepath.Rel(op.target, target)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		} else if len(op.staged) == 0 {
+			return "", true
+		}
+		return filepath.Join(op.staged, rel), true
+	}
+	return "", false
+}
+
+// Commit moves all the staged files into place. If any step fails, the changes made so far are rolled back.
+func (tx *Transaction) Commit() error {
+	for _, op := range tx.ops {
+		err := tx.apply(op)
+		if err != nil {
+			rollbackErr := tx.Rollback()
+			if rollbackErr != nil {
+				return fmt.Errorf("%s (rollback failed: %s)", err, rollbackErr)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func (tx *Transaction) apply(op *txOp) error {
+	if op.mkdir {
+		return tx.mkdir(op)
+	}
+	if _, err := os.Lstat(op.target); err == nil {
+		root, rel := tx.root(op.target)
+		op.backup = filepath.Join(root+backupSuffix, rel)
+		err = os.MkdirAll(filepath.Dir(op.backup), 0755)
+		if err != nil {
+			return err
+		}
+		err = os.Rename(op.target, op.backup)
+		if err != nil {
+			op.backup = ""
+			return fmt.Errorf("failed to move %s out of the way: %s", op.target, err)
+		}
+	}
+	tx.applied = append(tx.applied, op)
+	if len(op.staged) == 0 {
+		return nil
+	}
+	err := os.MkdirAll(filepath.Dir(op.target), 0755)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(op.staged, op.target)
+	if err != nil {
+		return fmt.Errorf("failed to move %s into place: %s", op.target, err)
+	}
+	return nil
+}
+
+// mkdir creates the directory of a mkdir operation along with its missing parents, which are removed on rollback.
+func (tx *Transaction) mkdir(op *txOp) error {
+	for dir := filepath.Clean(op.target); dir != filepath.Dir(dir); dir = filepath.Dir(dir) {
+		if _, err := os.Lstat(dir); err == nil {
+			break
+		}
+		op.created = append(op.created, dir)
+	}
+	tx.applied = append(tx.applied, op)
+	err := os.MkdirAll(op.target, 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %s", op.target, err)
+	}
+	return nil
+}
+
+// Rollback undoes all the changes made by Commit and removes the staging directories.
+func (tx *Transaction) Rollback() error {
+	var lastErr error
+	for i := len(tx.applied) - 1; i >= 0; i-- {
+		op := tx.applied[i]
+		if op.mkdir {
+			for _, dir := range op.created {
+				// The directory may contain files the user has added since, so only remove it if it's empty.
+				_ = os.Remove(dir)
+			}
+			continue
+		}
+		if len(op.staged) != 0 {
+			if _, err := os.Lstat(op.target); err == nil {
+				err = os.RemoveAll(op.target)
+				if err != nil {
+					lastErr = err
+					continue
+				}
+			}
+		}
+		if len(op.backup) != 0 {
+			err := os.Rename(op.backup, op.target)
+			if err != nil {
+				log.Errorf("Failed to restore %[1]s from %[2]s: %[3]s", op.target, op.backup, err)
+				lastErr = err
+			}
+		}
+	}
+	tx.applied = nil
+	if lastErr != nil {
+		// Keep the backups around so that the user can restore them manually.
+		tx.removeDirs(stagingSuffix)
+		return lastErr
+	}
+	tx.Cleanup()
+	return nil
+}
+
+// Cleanup removes the staging and backup directories. It should be called after a successful commit,
+// or instead of committing to discard the staged changes.
+func (tx *Transaction) Cleanup() {
+	tx.removeDirs(stagingSuffix)
+	tx.removeDirs(backupSuffix)
+}
+
+func (tx *Transaction) removeDirs(suffix string) {
+	for _, root := range tx.roots {
+		err := os.RemoveAll(root + suffix)
+		if err != nil {
+			log.Warnf("Failed to remove %[1]s: %[2]s", root+suffix, err)
+		}
+	}
+}
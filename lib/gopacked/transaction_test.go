@@ -0,0 +1,102 @@
+// goPacked - A simple text-based Minecraft modpack manager.
+// Copyright (C) 2019 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package gopacked
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "gopacked-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+func TestTransactionMkdir(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	tx := NewTransaction(dir)
+	tx.Mkdir(filepath.Join(dir, "config", "empty"))
+	if isDir(filepath.Join(dir, "config")) {
+		t.Fatal("directory was created before committing")
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("commit failed: %s", err)
+	}
+	if !isDir(filepath.Join(dir, "config", "empty")) {
+		t.Error("directory wasn't created on commit")
+	}
+}
+
+func TestTransactionMkdirRollback(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	existing := filepath.Join(dir, "existing")
+	if err := os.Mkdir(existing, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	tx := NewTransaction(dir)
+	tx.Mkdir(existing)
+	tx.Mkdir(filepath.Join(dir, "new", "nested"))
+	// Replacing a file whose staged version doesn't exist fails the commit.
+	tx.Replace(filepath.Join(dir+stagingSuffix, "missing"), filepath.Join(dir, "missing"))
+	if err := tx.Commit(); err == nil {
+		t.Fatal("expected commit to fail")
+	}
+	if isDir(filepath.Join(dir, "new")) {
+		t.Error("created directories weren't removed on rollback")
+	}
+	if !isDir(existing) {
+		t.Error("existing directory was removed on rollback")
+	}
+}
+
+func TestInstallDirectoryInTransaction(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	entry := FileEntry{Type: TypeDirectory, Children: map[string]FileEntry{
+		"config": {Type: TypeDirectory, Children: map[string]FileEntry{
+			"mymod": {Type: TypeDirectory},
+		}},
+	}}
+	dl := NewDownloader(1, 1)
+	tx := NewTransaction(dir)
+	entry.install(dl, tx, dir, "", SideClient)
+	if errors := dl.Run(); len(errors) != 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("commit failed: %s", err)
+	}
+	tx.Cleanup()
+	if !isDir(filepath.Join(dir, "config", "mymod")) {
+		t.Error("empty directory wasn't created by the transaction")
+	}
+}
@@ -0,0 +1,129 @@
+// goPacked - A simple text-based Minecraft modpack manager.
+// Copyright (C) 2019 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package gopacked
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// UntrustedKeyError is returned when a goPack definition is signed with a different key than the one that was
+// trusted when the goPack was first installed.
+type UntrustedKeyError struct {
+	Pack   string
+	Pinned string
+	Key    string
+}
+
+func (err UntrustedKeyError) Error() string {
+	if len(err.Key) == 0 {
+		return fmt.Sprintf("%s is not signed, but it was previously signed with %s", err.Pack, err.Pinned)
+	}
+	return fmt.Sprintf("%s is signed with %s, but the trusted key is %s", err.Pack, err.Key, err.Pinned)
+}
+
+// ParseSigningKey parses a base64-encoded ed25519 public key.
+func ParseSigningKey(key string) (ed25519.PublicKey, error) {
+	data, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, err
+	} else if len(data) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid public key length %d", len(data))
+	}
+	return ed25519.PublicKey(data), nil
+}
+
+// VerifySignature verifies the given detached signature of the raw definition data against the signing key in this
+// goPack. The signature is expected to be base64-encoded. If this goPack has no signing key, the signature is ignored.
+func (gp GoPack) VerifySignature(data, signature []byte) error {
+	if len(gp.SigningKey) == 0 {
+		return nil
+	} else if len(signature) == 0 {
+		return fmt.Errorf("definition has a signing key, but no signature")
+	}
+	key, err := ParseSigningKey(gp.SigningKey)
+	if err != nil {
+		return fmt.Errorf("failed to parse signing key: %s", err)
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(signature)))
+	if err != nil {
+		return fmt.Errorf("failed to decode signature: %s", err)
+	}
+	if !ed25519.Verify(key, data, sig) {
+		return fmt.Errorf("signature doesn't match signing key %s", gp.SigningKey)
+	}
+	return nil
+}
+
+// TrustStore contains the signing keys that were trusted on the first install of each goPack.
+type TrustStore struct {
+	path string
+	Keys map[string]string `json:"keys"`
+}
+
+// LoadTrustStore loads the trust store from the given file. If the file doesn't exist, an empty trust store is returned.
+func LoadTrustStore(path string) (*TrustStore, error) {
+	store := &TrustStore{path: path, Keys: make(map[string]string)}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return store, nil
+	} else if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, store)
+	if err != nil {
+		return nil, err
+	}
+	if store.Keys == nil {
+		store.Keys = make(map[string]string)
+	}
+	return store, nil
+}
+
+// Check checks whether the given signing key is allowed for the goPack with the given simple name.
+// Packs that don't have a trusted key yet accept any key.
+func (ts *TrustStore) Check(simpleName, key string) error {
+	pinned, ok := ts.Keys[simpleName]
+	if !ok || pinned == key {
+		return nil
+	}
+	return UntrustedKeyError{Pack: simpleName, Pinned: pinned, Key: key}
+}
+
+// Trust sets the trusted signing key of the goPack with the given simple name. An empty key removes the trust.
+func (ts *TrustStore) Trust(simpleName, key string) {
+	if len(key) == 0 {
+		delete(ts.Keys, simpleName)
+	} else {
+		ts.Keys[simpleName] = key
+	}
+}
+
+// Save saves the trust store to the file it was loaded from.
+func (ts *TrustStore) Save() error {
+	err := os.MkdirAll(filepath.Dir(ts.path), 0755)
+	if err != nil {
+		return err
+	}
+	return writeJSON(ts, ts.path)
+}
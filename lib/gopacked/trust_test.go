@@ -0,0 +1,107 @@
+// goPacked - A simple text-based Minecraft modpack manager.
+// Copyright (C) 2019 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package gopacked
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVerifySignature(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPublic, otherPrivate, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := base64.StdEncoding.EncodeToString(public)
+	data := []byte(`{"name": "Pack", "signing-key": "` + key + `"}`)
+	sign := func(private ed25519.PrivateKey, data []byte) []byte {
+		return []byte(base64.StdEncoding.EncodeToString(ed25519.Sign(private, data)) + "\n")
+	}
+
+	tests := []struct {
+		name      string
+		key       string
+		data      []byte
+		signature []byte
+		valid     bool
+	}{
+		{"valid", key, data, sign(private, data), true},
+		{"unsigned pack", "", data, nil, true},
+		{"missing signature", key, data, nil, false},
+		{"modified data", key, append(data, ' '), sign(private, data), false},
+		{"wrong key", key, data, sign(otherPrivate, data), false},
+		{"signature of other key", base64.StdEncoding.EncodeToString(otherPublic), data, sign(private, data), false},
+		{"invalid signature", key, data, []byte("not base64!"), false},
+		{"truncated signature", key, data, sign(private, data)[:20], false},
+		{"invalid key", "AAAA", data, sign(private, data), false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := GoPack{SigningKey: test.key}.VerifySignature(test.data, test.signature)
+			if test.valid && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			} else if !test.valid && err == nil {
+				t.Error("expected verification to fail")
+			}
+		})
+	}
+}
+
+func TestTrustStore(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "trust", "keys.json")
+
+	store, err := LoadTrustStore(path)
+	if err != nil {
+		t.Fatalf("failed to load missing trust store: %s", err)
+	} else if err = store.Check("pack", "key1"); err != nil {
+		t.Errorf("expected any key to be accepted before trusting one, got %s", err)
+	}
+	store.Trust("pack", "key1")
+	if err = store.Save(); err != nil {
+		t.Fatalf("failed to save trust store: %s", err)
+	}
+
+	store, err = LoadTrustStore(path)
+	if err != nil {
+		t.Fatalf("failed to load trust store: %s", err)
+	}
+	if err = store.Check("pack", "key1"); err != nil {
+		t.Errorf("trusted key was rejected: %s", err)
+	}
+	if _, ok := store.Check("pack", "key2").(UntrustedKeyError); !ok {
+		t.Error("expected a different key to be rejected")
+	}
+	if _, ok := store.Check("pack", "").(UntrustedKeyError); !ok {
+		t.Error("expected an unsigned definition to be rejected")
+	}
+	if err = store.Check("other", "key2"); err != nil {
+		t.Errorf("keys of other packs shouldn't affect each other: %s", err)
+	}
+	store.Trust("pack", "")
+	if err = store.Check("pack", "key2"); err != nil {
+		t.Errorf("expected any key to be accepted after removing the trust, got %s", err)
+	}
+}
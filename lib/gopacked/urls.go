@@ -0,0 +1,191 @@
+// goPacked - A simple text-based Minecraft modpack manager.
+// Copyright (C) 2019 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package gopacked
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// HTTPClient is the client used to download goPack definitions and files. It only supports HTTP(S).
+var HTTPClient = &http.Client{}
+
+// LocalHTTPClient is like HTTPClient, but it also supports file:// URLs, which makes it possible to install goPacks from
+// the local file system. It must only be used for goPacks loaded from the local file system (see GoPack.IsLocal),
+// as otherwise remote goPacks could copy any local file into the installation.
+var LocalHTTPClient = &http.Client{
+	Transport: schemeTransport{
+		"file": http.NewFileTransport(localFileSystem{}),
+	},
+}
+
+// ClientFor returns the client to use for fetching the given URL: LocalHTTPClient for file:// URLs and HTTPClient
+// for everything else.
+func ClientFor(rawURL string) *http.Client {
+	if isLocalURL(rawURL) {
+		return LocalHTTPClient
+	}
+	return HTTPClient
+}
+
+func isLocalURL(rawURL string) bool {
+	parsed, err := url.Parse(rawURL)
+	return err == nil && parsed.Scheme == "file"
+}
+
+// IsLocal checks whether the goPack definition was loaded from the local file system. Only local goPacks may
+// download files from file:// URLs.
+func (gp GoPack) IsLocal() bool {
+	return isLocalURL(gp.Source)
+}
+
+// schemeTransport uses a different transport for the URL schemes in the map and the default transport for others.
+type schemeTransport map[string]http.RoundTripper
+
+func (st schemeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if transport, ok := st[req.URL.Scheme]; ok {
+		return transport.RoundTrip(req)
+	}
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// localFileSystem serves files by the path in a file:// URL.
+type localFileSystem struct{}
+
+func (localFileSystem) Open(name string) (http.File, error) {
+	// file:///C:/path/to/file has the path /C:/path/to/file on Windows.
+	if runtime.GOOS == "windows" && len(name) > 2 && name[0] == '/' && name[2] == ':' {
+		name = name[1:]
+	}
+	return os.Open(filepath.FromSlash(name))
+}
+
+// FileURL returns the file:// URL of the given local path.
+func FileURL(path string) (string, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	path = filepath.ToSlash(path)
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return (&url.URL{Scheme: "file", Path: path}).String(), nil
+}
+
+// SavedDefinitionSource returns the URL a saved copy of a goPack definition (e.g. the gopacked.json of an installation)
+// was originally fetched from. goPacked saves the source next to the definition, but older copies only have the
+// update-url, which the definition was normally fetched from. If neither is known, an empty string is returned, as the
+// copy can't be assumed to have come from the local file system.
+func SavedDefinitionSource(data []byte) string {
+	definition, err := decodeDefinition(data)
+	if err != nil {
+		return ""
+	}
+	for _, field := range []string{"source", "update-url"} {
+		value, _ := definition[field].(string)
+		if parsed, err := url.Parse(value); err == nil && parsed.IsAbs() {
+			return value
+		}
+	}
+	return ""
+}
+
+// ResolveURLs resolves the relative URLs in the given goPack definition against the given base URL, which should be
+// the URL the definition was fetched from. The url and mirrors fields of all file entries are resolved, as well as
+// update-url and extends. Definitions that weren't loaded from the local file system can't refer to other local
+// definitions with update-url or extends.
+func ResolveURLs(data []byte, baseURL string) ([]byte, error) {
+	definition, err := decodeDefinition(data)
+	if err != nil {
+		return nil, err
+	}
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base URL %s: %s", baseURL, err)
+	} else if !base.IsAbs() {
+		return nil, fmt.Errorf("base URL %s is not absolute", baseURL)
+	}
+	for _, field := range []string{"update-url", "extends"} {
+		definition[field], err = resolveURL(base, definition[field])
+		if err != nil {
+			return nil, err
+		}
+		if definition[field] == nil {
+			delete(definition, field)
+		} else if str, _ := definition[field].(string); base.Scheme != "file" && isLocalURL(str) {
+			return nil, fmt.Errorf("%s of a definition loaded from %s can't be a local file", field, baseURL)
+		}
+	}
+	for _, field := range []string{"mcl-version", "files"} {
+		err = resolveEntryURLs(base, definition[field])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(definition)
+}
+
+func resolveEntryURLs(base *url.URL, rawEntry interface{}) (err error) {
+	entry, ok := rawEntry.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	if _, ok = entry["url"]; ok {
+		entry["url"], err = resolveURL(base, entry["url"])
+		if err != nil {
+			return err
+		}
+	}
+	if mirrors, ok := entry["mirrors"].([]interface{}); ok {
+		for i, mirror := range mirrors {
+			mirrors[i], err = resolveURL(base, mirror)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if children, ok := entry["children"].(map[string]interface{}); ok {
+		for _, child := range children {
+			err = resolveEntryURLs(base, child)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// resolveURL resolves the given raw URL against the base URL. Values that aren't non-empty strings are returned as-is.
+func resolveURL(base *url.URL, rawURL interface{}) (interface{}, error) {
+	str, ok := rawURL.(string)
+	if !ok || len(str) == 0 || strings.Contains(str, "${") {
+		// URLs with template variables are resolved after the variables are replaced.
+		return rawURL, nil
+	}
+	ref, err := url.Parse(str)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL %s: %s", str, err)
+	}
+	return base.ResolveReference(ref).String(), nil
+}
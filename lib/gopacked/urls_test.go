@@ -0,0 +1,64 @@
+// goPacked - A simple text-based Minecraft modpack manager.
+// Copyright (C) 2019 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package gopacked
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSavedDefinitionSource(t *testing.T) {
+	tests := []struct {
+		name       string
+		definition string
+		source     string
+	}{
+		{"saved source", `{"source": "file:///packs/pack.json", "update-url": ""}`, "file:///packs/pack.json"},
+		{"source over update-url", `{"source": "http://a.example/pack.json", "update-url": "http://b.example/pack.json"}`, "http://a.example/pack.json"},
+		{"legacy update-url", `{"update-url": "http://example.com/pack.json"}`, "http://example.com/pack.json"},
+		{"relative update-url", `{"update-url": "pack.json"}`, ""},
+		{"no update-url", `{"update-url": ""}`, ""},
+		{"invalid", `{`, ""},
+	}
+	for _, test := range tests {
+		if source := SavedDefinitionSource([]byte(test.definition)); source != test.source {
+			t.Errorf("%s: expected %q, got %q", test.name, test.source, source)
+		}
+	}
+}
+
+func TestSavedRemoteDefinitionIsNotLocal(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	gp := GoPack{Name: "Pack", SimpleName: "pack", Source: "http://example.com/pack.json"}
+	path := filepath.Join(dir, "gopacked.json")
+	if err := gp.Save(path); err != nil {
+		t.Fatalf("failed to save definition: %s", err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read definition: %s", err)
+	}
+	loaded := GoPack{Source: SavedDefinitionSource(data)}
+	if loaded.Source != gp.Source {
+		t.Errorf("expected saved source %q, got %q", gp.Source, loaded.Source)
+	} else if loaded.IsLocal() {
+		t.Errorf("saved copy of a remote definition without an update-url is treated as local")
+	}
+}
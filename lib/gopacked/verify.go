@@ -0,0 +1,187 @@
+// goPacked - A simple text-based Minecraft modpack manager.
+// Copyright (C) 2019 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package gopacked
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ProblemType is the type of a problem found when verifying an installation.
+type ProblemType string
+
+const (
+	ProblemMissing    ProblemType = "missing"
+	ProblemChanged    ProblemType = "changed"
+	ProblemUnexpected ProblemType = "unexpected"
+)
+
+// Problem is a file in an installation that doesn't match the goPack definition.
+type Problem struct {
+	Type ProblemType
+	// Path is the path of the file that has the problem.
+	Path string
+	// Name is the display name of the file entry the file belongs to. Empty for unexpected files.
+	Name string
+	// Entry is the file entry the file belongs to, and EntryPath is the path the entry is installed to.
+	Entry     FileEntry
+	EntryPath string
+}
+
+// Verify compares the files on disk against this file entry and the given install state.
+func (fe FileEntry) Verify(state *InstallState, path, name string, side Side) (problems []Problem) {
+	if !fe.applies(side) {
+		return
+	}
+	switch fe.Type {
+	case TypeDirectory:
+		expected := make(map[string]bool)
+		hasFiles := false
+		for key, value := range fe.Children {
+			childPath := value.path(path, key)
+			expected[childPath] = true
+			if value.Type == TypeFile || value.Type == TypeInline {
+				hasFiles = true
+			}
+			problems = append(problems, value.Verify(state, childPath, key, side)...)
+		}
+		// Only directories with file children are checked for unexpected files, as other directories
+		// (like the game directory itself) are expected to contain files created by the game.
+		if hasFiles && len(name) != 0 {
+			problems = append(problems, findUnexpected(state, path, expected)...)
+		}
+	case TypeFile, TypeInline:
+		problems = append(problems, fe.verifyFile(state, path, path, name)...)
+	case TypePatch:
+		problems = append(problems, fe.verifyPatch(state, path, name)...)
+	case TypeZipArchive, TypeTarGzArchive, TypeTarBz2Archive:
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return []Problem{{Type: ProblemMissing, Path: path, Name: name, Entry: fe, EntryPath: path}}
+		}
+		if fe.merges() {
+			for _, key := range state.Owned(path, name) {
+				problems = append(problems, fe.verifyFile(state, state.Path(key), path, name)...)
+			}
+			return
+		}
+		prefix := state.Key(path) + "/"
+		for _, key := range state.Keys() {
+			if strings.HasPrefix(key, prefix) {
+				problems = append(problems, fe.verifyFile(state, state.Path(key), path, name)...)
+			}
+		}
+	}
+	return
+}
+
+func (fe FileEntry) verifyFile(state *InstallState, path, entryPath, name string) []Problem {
+	problem := Problem{Path: path, Name: name, Entry: fe, EntryPath: entryPath}
+	info, err := os.Stat(path)
+	if err != nil {
+		problem.Type = ProblemMissing
+		return []Problem{problem}
+	} else if fe.keepsModified() {
+		// Modified files are kept on update, so they're not broken, and repairing them would lose the changes.
+		return nil
+	}
+	// Patched files are expected to differ from the content or checksum in the definition, but their records are
+	// updated when they're patched.
+	patched := state.IsPatched(path)
+	if fe.Type == TypeInline && !patched {
+		// The expected content is known, so there's no need to look at the install state.
+		if fe.contentChanged(nil, path) {
+			problem.Type = ProblemChanged
+			return []Problem{problem}
+		}
+		return nil
+	}
+	if fe.Type == TypeFile && fe.HasChecksum() && !patched {
+		if fe.VerifyChecksum(path) != nil {
+			problem.Type = ProblemChanged
+			return []Problem{problem}
+		}
+		return nil
+	}
+	record := state.Get(path)
+	if record == nil {
+		return nil
+	} else if record.Size != info.Size() {
+		problem.Type = ProblemChanged
+		return []Problem{problem}
+	}
+	_, hash, err := hashFile(path)
+	if err != nil || hash != record.SHA256 {
+		problem.Type = ProblemChanged
+		return []Problem{problem}
+	}
+	return nil
+}
+
+// verifyPatch checks that the config keys this patch entry changed in the file at path still have the values the
+// patch set. Keys kept by the keep-if-modified and merge policies are allowed to have been changed by the user.
+func (fe FileEntry) verifyPatch(state *InstallState, path, name string) []Problem {
+	problem := Problem{Path: path, Name: name, Entry: fe, EntryPath: path}
+	patched := state.PatchedKeys(path, name)
+	if _, err := os.Stat(path); err != nil {
+		problem.Type = ProblemMissing
+		return []Problem{problem}
+	} else if len(patched) == 0 && len(fe.Set)+len(fe.Unset) > 0 && !state.Legacy {
+		// The patch was never applied successfully.
+		problem.Type = ProblemChanged
+		return []Problem{problem}
+	} else if record := state.Get(path); record != nil {
+		// If the patched file doesn't match its record, the file will be repaired, and the patch must be reapplied.
+		if _, hash, err := hashFile(path); err != nil || hash != record.SHA256 {
+			problem.Type = ProblemChanged
+			return []Problem{problem}
+		}
+	}
+	if fe.keepsModified() {
+		return nil
+	}
+	cfg, err := loadConfigFile(fe.patchFormat(path), path)
+	if err != nil {
+		problem.Type = ProblemChanged
+		return []Problem{problem}
+	}
+	for key, record := range patched {
+		if current, exists := cfg.Get(key); !sameValue(current, exists, record) {
+			problem.Type = ProblemChanged
+			return []Problem{problem}
+		}
+	}
+	return nil
+}
+
+// findUnexpected finds the files in the directory at path that aren't expected or recorded in the install state.
+// Recorded files are expected, as they may have been extracted there by a merge-mode archive.
+func findUnexpected(state *InstallState, path string, expected map[string]bool) (problems []Problem) {
+	files, err := ioutil.ReadDir(path)
+	if err != nil {
+		return
+	}
+	for _, file := range files {
+		filePath := filepath.Join(path, file.Name())
+		if !file.Mode().IsRegular() || expected[filePath] || state.Get(filePath) != nil {
+			continue
+		}
+		problems = append(problems, Problem{Type: ProblemUnexpected, Path: filePath})
+	}
+	return
+}
@@ -23,22 +23,147 @@ import (
 	"strings"
 )
 
-// Version contains four version number levels. Level 1 is the highest in importance.
-// Higher importance means overriding of lower importances.
-type Version []int
+// Version is a version number with any amount of dot-separated numbers and optional pre-release and build segments,
+// e.g. 1.2.3.4, 1.2.3-beta.2 or 1.16.5-36.2.0+build.5. Versions are ordered like semantic versions: the numbers are
+// compared level by level (missing levels count as zeros), a version with a pre-release segment is smaller than the
+// same version without one and the build segment is ignored.
+//
+// The empty version is valid and equal to 0.
+type Version string
+
+// parsedVersion contains the segments of a version.
+type parsedVersion struct {
+	numbers    []int
+	preRelease []string
+	build      []string
+}
+
+// ParseVersion parses and normalizes a version from a string.
+func ParseVersion(str string) (Version, error) {
+	parsed, err := parseVersion(str)
+	if err != nil {
+		return "", err
+	}
+	return parsed.version(), nil
+}
+
+func parseVersion(str string) (parsed parsedVersion, err error) {
+	if len(str) == 0 {
+		return
+	}
+	if index := strings.IndexRune(str, '+'); index >= 0 {
+		parsed.build, err = parseIdentifiers(str[index+1:])
+		if err != nil {
+			return parsed, fmt.Errorf("invalid build segment in %s: %s", str, err)
+		}
+		str = str[:index]
+	}
+	numbers := str
+	if index := strings.IndexRune(str, '-'); index >= 0 {
+		parsed.preRelease, err = parseIdentifiers(str[index+1:])
+		if err != nil {
+			return parsed, fmt.Errorf("invalid pre-release segment in %s: %s", str, err)
+		}
+		numbers = str[:index]
+	}
+	pieces := strings.Split(numbers, ".")
+	parsed.numbers = make([]int, len(pieces))
+	for i, piece := range pieces {
+		if !isNumeric(piece) {
+			return parsed, fmt.Errorf("invalid version number %s: %q is not a number", str, piece)
+		}
+		parsed.numbers[i], err = strconv.Atoi(piece)
+		if err != nil {
+			return parsed, fmt.Errorf("invalid version number %s: %s", str, err)
+		}
+	}
+	return parsed, nil
+}
+
+func parseIdentifiers(str string) ([]string, error) {
+	identifiers := strings.Split(str, ".")
+	for _, identifier := range identifiers {
+		if len(identifier) == 0 {
+			return nil, fmt.Errorf("empty identifier")
+		}
+		for _, char := range identifier {
+			if (char < '0' || char > '9') && (char < 'a' || char > 'z') && (char < 'A' || char > 'Z') && char != '-' {
+				return nil, fmt.Errorf("invalid character %q in %s", char, identifier)
+			}
+		}
+	}
+	return identifiers, nil
+}
+
+func isNumeric(str string) bool {
+	if len(str) == 0 {
+		return false
+	}
+	for _, char := range str {
+		if char < '0' || char > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func (parsed parsedVersion) version() Version {
+	var buf strings.Builder
+	for i, val := range parsed.numbers {
+		if i > 0 {
+			buf.WriteRune('.')
+		}
+		buf.WriteString(strconv.Itoa(val))
+	}
+	if len(parsed.preRelease) != 0 {
+		buf.WriteRune('-')
+		buf.WriteString(strings.Join(parsed.preRelease, "."))
+	}
+	if len(parsed.build) != 0 {
+		buf.WriteRune('+')
+		buf.WriteString(strings.Join(parsed.build, "."))
+	}
+	return Version(buf.String())
+}
+
+// parse parses the version. Invalid versions are treated as the empty version, but they can only be created
+// without ParseVersion or UnmarshalJSON.
+func (ver Version) parse() parsedVersion {
+	parsed, err := parseVersion(string(ver))
+	if err != nil {
+		return parsedVersion{}
+	}
+	return parsed
+}
+
+// Numbers returns the dot-separated numbers of the version, e.g. [1 2 3] for 1.2.3-beta.
+func (ver Version) Numbers() []int {
+	return ver.parse().numbers
+}
+
+// PreRelease returns the pre-release segment of the version, e.g. beta.2 for 1.2.3-beta.2+build.5.
+func (ver Version) PreRelease() string {
+	return strings.Join(ver.parse().preRelease, ".")
+}
+
+// Build returns the build segment of the version, e.g. build.5 for 1.2.3-beta.2+build.5.
+func (ver Version) Build() string {
+	return strings.Join(ver.parse().build, ".")
+}
 
 // Compare compares this version to the given one.
 // Return value 1 means that this version is greater than the given one.
 // Return value 0 means that the versions are equal.
 // Return value -1 means that this version is smaller than the given one.
 func (ver Version) Compare(ver2 Version) int {
-	for i := 0; i < len(ver) || i < len(ver2); i++ {
+	parsed1, parsed2 := ver.parse(), ver2.parse()
+	for i := 0; i < len(parsed1.numbers) || i < len(parsed2.numbers); i++ {
 		var val1, val2 int
-		if len(ver) < i {
-			val1 = ver[i]
+		if i < len(parsed1.numbers) {
+			val1 = parsed1.numbers[i]
 		}
-		if len(ver2) < i {
-			val2 = ver2[i]
+		if i < len(parsed2.numbers) {
+			val2 = parsed2.numbers[i]
 		}
 		if val1 < val2 {
 			return -1
@@ -46,9 +171,53 @@ func (ver Version) Compare(ver2 Version) int {
 			return 1
 		}
 	}
+	return comparePreRelease(parsed1.preRelease, parsed2.preRelease)
+}
+
+// comparePreRelease compares pre-release segments like semantic versioning: no pre-release is greater than any
+// pre-release, numeric identifiers are compared numerically and are smaller than other identifiers, and a longer
+// segment is greater if the preceding identifiers are equal.
+func comparePreRelease(pre1, pre2 []string) int {
+	if len(pre1) == 0 && len(pre2) == 0 {
+		return 0
+	} else if len(pre1) == 0 {
+		return 1
+	} else if len(pre2) == 0 {
+		return -1
+	}
+	for i := 0; i < len(pre1) && i < len(pre2); i++ {
+		if result := compareIdentifiers(pre1[i], pre2[i]); result != 0 {
+			return result
+		}
+	}
+	if len(pre1) < len(pre2) {
+		return -1
+	} else if len(pre1) > len(pre2) {
+		return 1
+	}
 	return 0
 }
 
+func compareIdentifiers(id1, id2 string) int {
+	numeric1, numeric2 := isNumeric(id1), isNumeric(id2)
+	switch {
+	case numeric1 && numeric2:
+		// Compare by length first so that large numbers don't need to be parsed.
+		id1, id2 = strings.TrimLeft(id1, "0"), strings.TrimLeft(id2, "0")
+		if len(id1) != len(id2) {
+			if len(id1) < len(id2) {
+				return -1
+			}
+			return 1
+		}
+	case numeric1:
+		return -1
+	case numeric2:
+		return 1
+	}
+	return strings.Compare(id1, id2)
+}
+
 // IsGreater checks if this version is greater than the given one.
 func (ver Version) IsGreater(ver2 Version) bool {
 	return ver.Compare(ver2) == 1
@@ -65,14 +234,7 @@ func (ver Version) IsSmaller(ver2 Version) bool {
 }
 
 func (ver Version) String() string {
-	var buf strings.Builder
-	for i, val := range ver {
-		buf.WriteString(strconv.Itoa(val))
-		if i < len(ver)-1 {
-			buf.WriteRune('.')
-		}
-	}
-	return buf.String()
+	return string(ver)
 }
 
 func (ver *Version) UnmarshalJSON(blob []byte) error {
@@ -84,22 +246,3 @@ func (ver *Version) UnmarshalJSON(blob []byte) error {
 	*ver, err = ParseVersion(data)
 	return err
 }
-
-func (ver Version) MarshalJSON() ([]byte, error) {
-	return []byte(fmt.Sprintf("\"%s\"", ver.String())), nil
-}
-
-// ParseVersion parses a version from a string.
-func ParseVersion(str string) (Version, error) {
-	pieces := strings.Split(str, ".")
-
-	ver := make(Version, len(pieces))
-	var err error
-	for i, piece := range pieces {
-		ver[i], err = strconv.Atoi(piece)
-		if err != nil {
-			return ver, err
-		}
-	}
-	return ver, nil
-}
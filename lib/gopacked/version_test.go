@@ -0,0 +1,112 @@
+// goPacked - A simple text-based Minecraft modpack manager.
+// Copyright (C) 2019 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package gopacked
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		input      string
+		expected   Version
+		numbers    []int
+		preRelease string
+		build      string
+	}{
+		{"", "", nil, "", ""},
+		{"1", "1", []int{1}, "", ""},
+		{"1.2.3.4", "1.2.3.4", []int{1, 2, 3, 4}, "", ""},
+		{"01.002.3", "1.2.3", []int{1, 2, 3}, "", ""},
+		{"1.2.3-beta.2", "1.2.3-beta.2", []int{1, 2, 3}, "beta.2", ""},
+		{"1.16.5-36.2.0", "1.16.5-36.2.0", []int{1, 16, 5}, "36.2.0", ""},
+		{"1.2.3-rc-1+build.5", "1.2.3-rc-1+build.5", []int{1, 2, 3}, "rc-1", "build.5"},
+		{"1.0+20190101", "1.0+20190101", []int{1, 0}, "", "20190101"},
+	}
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			ver, err := ParseVersion(test.input)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if ver != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, ver)
+			}
+			if !reflect.DeepEqual(ver.Numbers(), test.numbers) && len(test.numbers)+len(ver.Numbers()) != 0 {
+				t.Errorf("expected numbers %v, got %v", test.numbers, ver.Numbers())
+			}
+			if ver.PreRelease() != test.preRelease || ver.Build() != test.build {
+				t.Errorf("expected pre-release %q and build %q, got %q and %q", test.preRelease, test.build,
+					ver.PreRelease(), ver.Build())
+			}
+		})
+	}
+
+	for _, input := range []string{"a", "1.a", "1..2", "1.", "-beta", "1.2-", "1.2-beta..1", "1.2+", "1.2-beta_1", "v1.2", " 1"} {
+		if ver, err := ParseVersion(input); err == nil {
+			t.Errorf("expected %q to be invalid, got %q", input, ver)
+		}
+	}
+}
+
+func TestVersionCompare(t *testing.T) {
+	// Each version is greater than the previous one.
+	ordered := []Version{
+		"0.9", "1.0.0-1", "1.0.0-2", "1.0.0-10", "1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-alpha.beta", "1.0.0-beta",
+		"1.0.0-beta.2", "1.0.0-beta.11", "1.0.0-rc.1", "1", "1.0.1", "1.2", "1.10", "1.16.5-36.2.0", "1.16.5", "2",
+	}
+	for i := range ordered {
+		for j := range ordered {
+			expected := 0
+			if i < j {
+				expected = -1
+			} else if i > j {
+				expected = 1
+			}
+			if result := ordered[i].Compare(ordered[j]); result != expected {
+				t.Errorf("%s compared to %s: expected %d, got %d", ordered[i], ordered[j], expected, result)
+			}
+		}
+	}
+
+	equal := [][2]Version{{"1", "1.0.0"}, {"", "0"}, {"1.0+a", "1.0+b"}, {"1.0-beta+a", "1-beta"}, {"1.0-01", "1.0-1"}}
+	for _, pair := range equal {
+		if !pair[0].IsEqual(pair[1]) || pair[0].IsGreater(pair[1]) || pair[0].IsSmaller(pair[1]) {
+			t.Errorf("expected %s to be equal to %s", pair[0], pair[1])
+		}
+	}
+}
+
+func TestVersionUnmarshalJSON(t *testing.T) {
+	var versions []Version
+	err := json.Unmarshal([]byte(`["1.12.2.4", "0.4.0", "1.2.3-beta.2+5", ""]`), &versions)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := []Version{"1.12.2.4", "0.4.0", "1.2.3-beta.2+5", ""}
+	if !reflect.DeepEqual(versions, expected) {
+		t.Errorf("expected %v, got %v", expected, versions)
+	}
+	for _, invalid := range []string{`"1.x"`, `[1, 2]`, `1.2`} {
+		var ver Version
+		if err = json.Unmarshal([]byte(invalid), &ver); err == nil {
+			t.Errorf("expected %s to be invalid, got %q", invalid, ver)
+		}
+	}
+}
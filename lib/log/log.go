@@ -20,6 +20,7 @@ import (
 	"bufio"
 	"fmt"
 	"os"
+	"sync"
 )
 
 var infoPrefix = []byte("[Info] ")
@@ -28,39 +29,46 @@ var errorPrefix = []byte("\x1b[31m[Error] ")
 var fatalPrefix = []byte("\x1b[35m[Fatal] ")
 var newline = []byte("\x1b[0m\n")
 
+// lock makes sure that messages logged from different goroutines don't get mixed up.
+var lock sync.Mutex
+
+// stdin is shared between calls to Inputf, as a new reader could buffer input meant for the next prompt.
+var stdin = bufio.NewReader(os.Stdin)
+
+func write(prefix []byte, message string, args ...interface{}) {
+	lock.Lock()
+	_, _ = os.Stdout.Write(prefix)
+	_, _ = os.Stdout.Write([]byte(fmt.Sprintf(message, args...)))
+	_, _ = os.Stdout.Write(newline)
+	lock.Unlock()
+}
+
 // Inputf prints the given message and then waits for input
 func Inputf(message string, args ...interface{}) string {
+	lock.Lock()
+	defer lock.Unlock()
 	_, _ = os.Stdout.Write([]byte(fmt.Sprintf(message, args...)))
 	_, _ = os.Stdout.Write([]byte(" "))
-	bio := bufio.NewReader(os.Stdin)
-	line, _ := bio.ReadString('\n')
+	line, _ := stdin.ReadString('\n')
 	return line
 }
 
 // Infof formats and prints the given message into stdout
 func Infof(message string, args ...interface{}) {
-	_, _ = os.Stdout.Write(infoPrefix)
-	_, _ = os.Stdout.Write([]byte(fmt.Sprintf(message, args...)))
-	_, _ = os.Stdout.Write(newline)
+	write(infoPrefix, message, args...)
 }
 
 // Warnf formats and prints the given message into stdout with a yellow color
 func Warnf(message string, args ...interface{}) {
-	_, _ = os.Stdout.Write(warnPrefix)
-	_, _ = os.Stdout.Write([]byte(fmt.Sprintf(message, args...)))
-	_, _ = os.Stdout.Write(newline)
+	write(warnPrefix, message, args...)
 }
 
 // Errorf formats and prints the given message into stderr with a red color
 func Errorf(message string, args ...interface{}) {
-	_, _ = os.Stdout.Write(errorPrefix)
-	_, _ = os.Stdout.Write([]byte(fmt.Sprintf(message, args...)))
-	_, _ = os.Stdout.Write(newline)
+	write(errorPrefix, message, args...)
 }
 
 // Fatalf formats and prints the given message into stderr with a purple color
 func Fatalf(message string, args ...interface{}) {
-	_, _ = os.Stdout.Write(fatalPrefix)
-	_, _ = os.Stdout.Write([]byte(fmt.Sprintf(message, args...)))
-	_, _ = os.Stdout.Write(newline)
+	write(fatalPrefix, message, args...)
 }